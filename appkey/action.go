@@ -0,0 +1,75 @@
+package appkey
+
+import (
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// Action is the next step a client must take to keep a session usable,
+// as reported by NextAction.
+type Action int
+
+const (
+	// ActionNone means no action can be determined, because the session
+	// is invalid. It is only returned along with an error.
+	ActionNone Action = iota
+
+	// ActionRefresh means the session should be refreshed with
+	// com.atproto.server.refreshSession.
+	ActionRefresh
+
+	// ActionReauth means the session cannot be refreshed, and the user must
+	// log in again, such as because its refresh token has expired or it was
+	// created with a master password.
+	ActionReauth
+)
+
+var actionNames = [...]string{
+	ActionNone:    "none",
+	ActionRefresh: "refresh",
+	ActionReauth:  "reauth",
+}
+
+// String returns a short name for a.
+func (a Action) String() string {
+	if a < 0 || int(a) >= len(actionNames) {
+		return "unknown"
+	}
+	return actionNames[a]
+}
+
+// NextAction returns the next action needed to keep sess usable, and how
+// long from now until it is needed, which suits a loop that sleeps until
+// the next action. A session is due for a refresh refreshBuffer before its
+// access token or, if sooner, its refresh token expires, so that refreshing
+// also renews a refresh token that is about to lapse. Once the refresh token
+// has expired, or if the session was created with a master password,
+// ActionReauth is returned with a zero duration.
+//
+// Expired tokens are not an error here, but any other problem that Check
+// would report is returned with ActionNone. The validation can be adjusted
+// with opts, such as WithNow to compute the action at another time, except
+// that master credentials and expired tokens are always allowed.
+func NextAction(sess *atproto.ServerCreateSession_Output, refreshBuffer time.Duration, opts ...Option) (action Action, after time.Duration, err error) {
+	cfg := newConfig(opts)
+	cfg.AllowMasterCredentials = true
+	cfg.IgnoreExpiry = true
+	res, err := inspect(sess, cfg)
+	if err != nil {
+		return ActionNone, 0, err
+	}
+	now := cfg.now
+	if res.IsMasterCredentials || !res.RefreshExpiry.After(now) {
+		return ActionReauth, 0, nil
+	}
+	deadline := res.AccessExpiry
+	if res.RefreshExpiry.Before(deadline) {
+		deadline = res.RefreshExpiry
+	}
+	after = deadline.Add(-refreshBuffer).Sub(now)
+	if after < 0 {
+		after = 0
+	}
+	return ActionRefresh, after, nil
+}
@@ -0,0 +1,93 @@
+// Package appkeytest provides utilities for testing code that uses package appkey.
+// It mints JWTs with configurable claims that resemble the session tokens
+// issued by a Bluesky PDS, so tests can produce app password, master password,
+// expired, and malformed tokens without a live server.
+package appkeytest
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Scopes carried by session tokens.
+const (
+	AppPassScope = "com.atproto.appPass"
+	MasterScope  = "com.atproto.access"
+	RefreshScope = "com.atproto.refresh"
+)
+
+// Key is the HMAC key used to sign tokens minted by Token.Encode.
+var Key = []byte("appkeytest signing key")
+
+// Token describes a JWT to mint. Claims with zero values are omitted,
+// so for example a Token with an empty Scope produces a token with no scope claim.
+type Token struct {
+	Scope     string
+	Subject   string
+	Audience  string
+	Issuer    string
+	IssuedAt  time.Time
+	NotBefore time.Time
+	ExpiresAt time.Time
+
+	// Claims holds additional claims, which override the fields above.
+	Claims map[string]any
+}
+
+// AppPass returns a Token for an access token created with an app password.
+func AppPass(did string, exp time.Time) Token {
+	return Token{Scope: AppPassScope, Subject: did, ExpiresAt: exp}
+}
+
+// Master returns a Token for an access token created with a master password.
+func Master(did string, exp time.Time) Token {
+	return Token{Scope: MasterScope, Subject: did, ExpiresAt: exp}
+}
+
+// Refresh returns a Token for a refresh token.
+func Refresh(did string, exp time.Time) Token {
+	return Token{Scope: RefreshScope, Subject: did, ExpiresAt: exp}
+}
+
+// MapClaims returns the claims of t.
+func (t Token) MapClaims() jwt.MapClaims {
+	c := jwt.MapClaims{}
+	set := func(name, v string) {
+		if v != "" {
+			c[name] = v
+		}
+	}
+	setTime := func(name string, v time.Time) {
+		if !v.IsZero() {
+			c[name] = v.Unix()
+		}
+	}
+	set("scope", t.Scope)
+	set("sub", t.Subject)
+	set("aud", t.Audience)
+	set("iss", t.Issuer)
+	setTime("iat", t.IssuedAt)
+	setTime("nbf", t.NotBefore)
+	setTime("exp", t.ExpiresAt)
+	for k, v := range t.Claims {
+		c[k] = v
+	}
+	return c
+}
+
+// Sign returns t as a JWT signed with HS256 using key.
+func (t Token) Sign(key []byte) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, t.MapClaims()).SignedString(key)
+}
+
+// Encode returns t as a JWT signed with HS256 using Key.
+// It panics if the token cannot be encoded, which only happens
+// if t.Claims holds values that cannot be marshaled to JSON.
+func (t Token) Encode() string {
+	s, err := t.Sign(Key)
+	if err != nil {
+		panic("appkeytest: encoding token: " + err.Error())
+	}
+	return s
+}
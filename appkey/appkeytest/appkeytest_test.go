@@ -0,0 +1,132 @@
+package appkeytest_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/thepudds/bluesky-aux/appkey"
+	"github.com/thepudds/bluesky-aux/appkey/appkeytest"
+)
+
+const did = "did:plc:abcdefghijklmnopqrstuvwx"
+
+func TestRoundTrip(t *testing.T) {
+	now := time.Now()
+	exp := now.Add(time.Hour)
+	withAccess := func(access appkeytest.Token) *atproto.ServerCreateSession_Output {
+		sess := appkeytest.NewSession(did, "alice.test", appkeytest.AppPassScope, exp)
+		sess.AccessJwt = access.Encode()
+		return sess
+	}
+	tests := []struct {
+		name string
+		sess *atproto.ServerCreateSession_Output
+		want error
+	}{
+		{"app password", appkeytest.NewSession(did, "alice.test", appkeytest.AppPassScope, exp), nil},
+		{"master password", appkeytest.NewSession(did, "alice.test", appkeytest.MasterScope, exp), appkey.ErrMasterCredentials},
+		{"expired", appkeytest.NewSession(did, "alice.test", appkeytest.AppPassScope, now.Add(-time.Minute)), appkey.ErrSessionExpired},
+		{"missing scope", appkeytest.NewSession(did, "alice.test", "", exp), appkey.ErrMissingScope},
+		{"AppPass token", withAccess(appkeytest.AppPass(did, exp)), nil},
+		{"Master token", withAccess(appkeytest.Master(did, exp)), appkey.ErrMasterCredentials},
+		{"Refresh token as access token", withAccess(appkeytest.Refresh(did, exp)), appkey.ErrLoginUnauthorized},
+	}
+	for _, tt := range tests {
+		err := appkey.Check(tt.sess)
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("%s: Check = %v, want nil", tt.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: Check = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestClaimsRoundTrip(t *testing.T) {
+	iat := time.Unix(1700000000, 0)
+	tok := appkeytest.Token{
+		Scope:     appkeytest.AppPassScope,
+		Subject:   did,
+		Audience:  appkeytest.PDSDID,
+		Issuer:    appkeytest.PDSDID,
+		IssuedAt:  iat,
+		NotBefore: iat,
+		ExpiresAt: iat.Add(appkeytest.AccessLifetime),
+	}
+	c, err := appkey.ParseClaims(tok.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Scope != tok.Scope || c.Subject != did || c.Issuer != appkeytest.PDSDID ||
+		len(c.Audience) != 1 || c.Audience[0] != appkeytest.PDSDID ||
+		!c.IssuedAt.Equal(iat) || !c.NotBefore.Equal(iat) || !c.ExpiresAt.Equal(tok.ExpiresAt) {
+		t.Errorf("ParseClaims = %+v, want the claims of %+v", c, tok)
+	}
+}
+
+func TestStaticPDS(t *testing.T) {
+	sess := appkeytest.NewSession(did, "alice.test", appkeytest.AppPassScope, time.Now().Add(time.Hour))
+	pds := appkeytest.StaticPDS(sess)
+	got, err := appkey.Login(context.Background(), pds.Client(), "alice.test", "app-pass")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if got.AccessJwt != sess.AccessJwt {
+		t.Errorf("Login returned a different session")
+	}
+}
+
+// trackingBody is a request body that records whether it was closed.
+type trackingBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *trackingBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestPDSRoundTrip(t *testing.T) {
+	pds := &appkeytest.PDS{
+		CreateSession: func(string, string) (*atproto.ServerCreateSession_Output, int) {
+			return nil, http.StatusUnauthorized
+		},
+	}
+	tests := []struct {
+		path, body string
+		status     string
+	}{
+		{"/xrpc/com.atproto.server.createSession", `{"identifier":"alice.test","password":"wrong"}`, "401 Unauthorized"},
+		{"/xrpc/com.atproto.server.createSession", `not json`, "400 Bad Request"},
+		{"/xrpc/com.atproto.server.refreshSession", ``, "501 Not Implemented"},
+		{"/xrpc/com.example.unknown", `{}`, "404 Not Found"},
+	}
+	for _, tt := range tests {
+		body := &trackingBody{Reader: strings.NewReader(tt.body)}
+		req, err := http.NewRequest(http.MethodPost, "https://pds.example.com"+tt.path, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := pds.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("RoundTrip(%s): %v", tt.path, err)
+		}
+		resp.Body.Close()
+		if resp.Status != tt.status {
+			t.Errorf("RoundTrip(%s %s) status = %q, want %q", tt.path, tt.body, resp.Status, tt.status)
+		}
+		if !body.closed {
+			t.Errorf("RoundTrip(%s %s) did not close the request body", tt.path, tt.body)
+		}
+	}
+}
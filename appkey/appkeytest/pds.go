@@ -0,0 +1,94 @@
+package appkeytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// PDS is an http.RoundTripper that simulates the session endpoints of a PDS,
+// so that code calling appkey.Login and similar functions can be tested
+// without a live server. Use Client to obtain an xrpc.Client that sends
+// its requests to the PDS.
+type PDS struct {
+	// CreateSession handles com.atproto.server.createSession, returning the
+	// session to respond with, or a status other than 200 for an error
+	// response, such as 401 for a wrong password or 429 for a rate limit.
+	// If nil, requests fail with 501.
+	CreateSession func(identifier, password string) (*atproto.ServerCreateSession_Output, int)
+
+	// RefreshSession handles com.atproto.server.refreshSession, which is
+	// authenticated by refreshJwt, in the same way as CreateSession.
+	// If nil, requests fail with 501.
+	RefreshSession func(refreshJwt string) (*atproto.ServerCreateSession_Output, int)
+}
+
+// StaticPDS returns a PDS that responds to every createSession and
+// refreshSession request with sess.
+func StaticPDS(sess *atproto.ServerCreateSession_Output) *PDS {
+	return &PDS{
+		CreateSession:  func(string, string) (*atproto.ServerCreateSession_Output, int) { return sess, http.StatusOK },
+		RefreshSession: func(string) (*atproto.ServerCreateSession_Output, int) { return sess, http.StatusOK },
+	}
+}
+
+// Client returns an xrpc.Client whose requests are handled by p.
+func (p *PDS) Client() *xrpc.Client {
+	return &xrpc.Client{
+		Host:   "https://pds.example.com",
+		Client: &http.Client{Transport: p},
+	}
+}
+
+// RoundTrip implements http.RoundTripper. As the interface requires,
+// it closes the request body, even if the request cannot be handled.
+func (p *PDS) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	var sess *atproto.ServerCreateSession_Output
+	status := http.StatusNotImplemented
+	switch strings.TrimPrefix(req.URL.Path, "/xrpc/") {
+	case "com.atproto.server.createSession":
+		if p.CreateSession != nil {
+			var in atproto.ServerCreateSession_Input
+			if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+				return respond(req, http.StatusBadRequest, nil), nil
+			}
+			sess, status = p.CreateSession(in.Identifier, in.Password)
+		}
+	case "com.atproto.server.refreshSession":
+		if p.RefreshSession != nil {
+			refreshJwt := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+			sess, status = p.RefreshSession(refreshJwt)
+		}
+	default:
+		status = http.StatusNotFound
+	}
+	return respond(req, status, sess), nil
+}
+
+// respond builds a response to req with the given status. A successful
+// response carries sess as its body, and an error response carries an
+// XRPC error body.
+func respond(req *http.Request, status int, sess *atproto.ServerCreateSession_Output) *http.Response {
+	var body interface{} = sess
+	if status != http.StatusOK {
+		body = map[string]string{"error": http.StatusText(status)}
+	}
+	data, _ := json.Marshal(body)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,51 @@
+package appkeytest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// PDSDID is the audience and issuer of the tokens in sessions built by NewSession.
+const PDSDID = "did:web:pds.example.com"
+
+// Token lifetimes used by NewSession, which match those of a Bluesky PDS.
+const (
+	AccessLifetime  = 2 * time.Hour
+	RefreshLifetime = 60 * 24 * time.Hour
+)
+
+// NewSession builds a createSession response for the account with the
+// given DID and handle, whose access token has the given scope and expires
+// at exp. The access token was issued AccessLifetime before exp, and the
+// refresh token expires RefreshLifetime after that.
+//
+// Use AppPassScope or MasterScope as the scope to simulate logging in
+// with an app password or a master password.
+func NewSession(did, handle, scope string, exp time.Time) *atproto.ServerCreateSession_Output {
+	iat := exp.Add(-AccessLifetime)
+	access := Token{
+		Scope:     scope,
+		Subject:   did,
+		Audience:  PDSDID,
+		Issuer:    PDSDID,
+		IssuedAt:  iat,
+		ExpiresAt: exp,
+	}
+	refresh := Token{
+		Scope:     RefreshScope,
+		Subject:   did,
+		Audience:  PDSDID,
+		Issuer:    PDSDID,
+		IssuedAt:  iat,
+		ExpiresAt: iat.Add(RefreshLifetime),
+		Claims:    map[string]any{"jti": fmt.Sprintf("%s-%d", did, iat.Unix())},
+	}
+	return &atproto.ServerCreateSession_Output{
+		AccessJwt:  access.Encode(),
+		RefreshJwt: refresh.Encode(),
+		Did:        did,
+		Handle:     handle,
+	}
+}
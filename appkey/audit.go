@@ -0,0 +1,43 @@
+package appkey
+
+import (
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// AuditSink records validation decisions, such as to an append-only audit log.
+// Record must be safe for concurrent use if the CheckOptions holding the
+// sink are shared between goroutines.
+type AuditSink interface {
+	Record(event ValidationEvent)
+}
+
+// ValidationEvent describes the outcome of validating a session.
+// It never contains the session's tokens.
+type ValidationEvent struct {
+	// DID is the Did field of the session, or empty for a nil session and
+	// when only tokens are validated, such as by CheckTokens.
+	DID string `json:"did"`
+
+	// Outcome classifies the result of the validation.
+	Outcome Category `json:"outcome"`
+
+	// Time is the time at which the session was validated.
+	Time time.Time `json:"time"`
+
+	// Fingerprint identifies the session without revealing its tokens,
+	// as computed by Fingerprint. It is empty if the session is too
+	// malformed to have a fingerprint.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// recordAudit reports the outcome of validating sess to cfg.AuditSink.
+func (cfg checkConfig) recordAudit(sess *atproto.ServerCreateSession_Output, err error) {
+	event := ValidationEvent{Outcome: Classify(err), Time: cfg.now}
+	if sess != nil {
+		event.DID = sess.Did
+		event.Fingerprint, _ = Fingerprint(sess)
+	}
+	cfg.AuditSink.Record(event)
+}
@@ -0,0 +1,128 @@
+package appkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// DefaultRefreshWithin is the window before the access token expires
+// in which an Authenticator refreshes the session.
+const DefaultRefreshWithin = 5 * time.Minute
+
+// Authenticator manages a session for an xrpc client. It logs in on first
+// use, validates each session with Check, and refreshes the session
+// when its access token is about to expire.
+// An Authenticator is safe for concurrent use.
+type Authenticator struct {
+	// RefreshWithin is the window before the access token expires in which
+	// the session is refreshed. If zero, DefaultRefreshWithin is used.
+	RefreshWithin time.Duration
+
+	client     *xrpc.Client
+	identifier string
+	password   string
+
+	mu   sync.Mutex
+	sess *atproto.ServerCreateSession_Output
+}
+
+// NewAuthenticator returns an Authenticator that logs in to the PDS behind
+// client with identifier and password. No network calls are made until
+// Token is called. Like Login, the client is not modified.
+func NewAuthenticator(client *xrpc.Client, identifier, password string) *Authenticator {
+	return &Authenticator{client: client, identifier: identifier, password: password}
+}
+
+// Token returns a valid access token, logging in or refreshing the session
+// as needed. If the password is a master password rather than an app
+// password, the returned error matches ErrMasterCredentials.
+// If the refresh token has expired, or the server rejects it as expired or
+// unauthorized, Token logs in again from scratch. If a refresh fails for
+// another reason while the current access token is still valid, Token
+// returns the current access token and tries to refresh again on the next
+// call; once the access token has expired, it logs in again instead.
+func (a *Authenticator) Token(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.sess != nil {
+		expired, err := RefreshExpiresWithin(a.sess, 0)
+		if err != nil || expired {
+			a.sess = nil
+		}
+	}
+	if a.sess == nil {
+		return a.login(ctx)
+	}
+
+	within := a.RefreshWithin
+	if within == 0 {
+		within = DefaultRefreshWithin
+	}
+	refresh, err := ShouldRefresh(a.sess, within)
+	if err != nil {
+		return "", err
+	}
+	if !refresh {
+		return a.sess.AccessJwt, nil
+	}
+	err = a.refresh(ctx)
+	if err == nil {
+		return a.sess.AccessJwt, nil
+	}
+	if errors.Is(err, ErrSessionExpired) || errors.Is(err, ErrLoginUnauthorized) {
+		// The server no longer accepts the refresh token.
+		a.sess = nil
+		return a.login(ctx)
+	}
+	if expired, expErr := ShouldRefresh(a.sess, 0); expErr == nil && !expired {
+		return a.sess.AccessJwt, nil
+	}
+	// Without a usable access token, logging in again is the only way to get one.
+	a.sess = nil
+	return a.login(ctx)
+}
+
+// login logs in from scratch and records the new session.
+// It must be called with a.mu held.
+func (a *Authenticator) login(ctx context.Context) (string, error) {
+	sess, err := Login(ctx, a.client, a.identifier, a.password)
+	if err != nil {
+		return "", err
+	}
+	a.sess = sess
+	return sess.AccessJwt, nil
+}
+
+// Session returns a copy of the current session, or nil if Token
+// has not yet successfully logged in.
+func (a *Authenticator) Session() *atproto.ServerCreateSession_Output {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return Clone(a.sess)
+}
+
+// refresh calls com.atproto.server.refreshSession, which authenticates
+// with the refresh token rather than the access token, and replaces
+// the tokens of the current session with the validated result.
+// It must be called with a.mu held.
+func (a *Authenticator) refresh(ctx context.Context) error {
+	client := *a.client
+	client.Auth = &xrpc.AuthInfo{
+		AccessJwt:  a.sess.RefreshJwt,
+		RefreshJwt: a.sess.RefreshJwt,
+		Handle:     a.sess.Handle,
+		Did:        a.sess.Did,
+	}
+	out, err := atproto.ServerRefreshSession(ctx, &client)
+	if err != nil {
+		return fmt.Errorf("refreshing session: %w", FromXRPCError(err))
+	}
+	return ApplyRefresh(a.sess, out)
+}
@@ -0,0 +1,161 @@
+package appkey
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/thepudds/bluesky-aux/appkey/appkeytest"
+)
+
+// countingPDS is an appkeytest.PDS that counts its requests, and issues
+// sessions whose access tokens expire at the time returned by exp.
+type countingPDS struct {
+	logins, refreshes int
+	scope             string
+	exp               func() time.Time
+	refreshStatus     int
+}
+
+func (p *countingPDS) pds() *appkeytest.PDS {
+	return &appkeytest.PDS{
+		CreateSession: func(identifier, password string) (*atproto.ServerCreateSession_Output, int) {
+			p.logins++
+			if password != "secret" {
+				return nil, http.StatusUnauthorized
+			}
+			return appkeytest.NewSession(testDID, identifier, p.scope, p.exp()), http.StatusOK
+		},
+		RefreshSession: func(string) (*atproto.ServerCreateSession_Output, int) {
+			p.refreshes++
+			if p.refreshStatus != http.StatusOK {
+				return nil, p.refreshStatus
+			}
+			return appkeytest.NewSession(testDID, "alice.test", p.scope, time.Now().Add(2*time.Hour)), http.StatusOK
+		},
+	}
+}
+
+func newCountingPDS(accessLifetime time.Duration) *countingPDS {
+	return &countingPDS{
+		scope:         appkeytest.AppPassScope,
+		exp:           func() time.Time { return time.Now().Add(accessLifetime) },
+		refreshStatus: http.StatusOK,
+	}
+}
+
+func TestAuthenticatorLogin(t *testing.T) {
+	ctx := context.Background()
+	p := newCountingPDS(time.Hour)
+	a := NewAuthenticator(p.pds().Client(), "alice.test", "secret")
+	if a.Session() != nil {
+		t.Errorf("Session before Token = non-nil, want nil")
+	}
+	first, err := a.Token(ctx)
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	second, err := a.Token(ctx)
+	if err != nil || second != first {
+		t.Errorf("second Token = %v, want the same token", err)
+	}
+	if p.logins != 1 || p.refreshes != 0 {
+		t.Errorf("logins, refreshes = %d, %d; want 1, 0", p.logins, p.refreshes)
+	}
+	if sess := a.Session(); sess == nil || sess.AccessJwt != first {
+		t.Errorf("Session does not hold the returned token")
+	}
+}
+
+func TestAuthenticatorLoginRejected(t *testing.T) {
+	ctx := context.Background()
+	p := newCountingPDS(time.Hour)
+	p.scope = appkeytest.MasterScope
+	a := NewAuthenticator(p.pds().Client(), "alice.test", "secret")
+	if _, err := a.Token(ctx); !errors.Is(err, ErrMasterCredentials) {
+		t.Errorf("Token with a master password = %v, want ErrMasterCredentials", err)
+	}
+	if a.Session() != nil {
+		t.Errorf("Session after a master password login = non-nil, want nil")
+	}
+
+	a = NewAuthenticator(newCountingPDS(time.Hour).pds().Client(), "alice.test", "wrong")
+	if _, err := a.Token(ctx); !errors.Is(err, ErrLoginUnauthorized) {
+		t.Errorf("Token with a wrong password = %v, want ErrLoginUnauthorized", err)
+	}
+}
+
+func TestAuthenticatorRefresh(t *testing.T) {
+	ctx := context.Background()
+	// Sessions from login expire within DefaultRefreshWithin,
+	// so the second call to Token refreshes.
+	p := newCountingPDS(time.Minute)
+	a := NewAuthenticator(p.pds().Client(), "alice.test", "secret")
+	first, err := a.Token(ctx)
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	second, err := a.Token(ctx)
+	if err != nil {
+		t.Fatalf("Token after refresh: %v", err)
+	}
+	if second == first {
+		t.Errorf("Token near expiry returned the old token, want a refreshed one")
+	}
+	if p.logins != 1 || p.refreshes != 1 {
+		t.Errorf("logins, refreshes = %d, %d; want 1, 1", p.logins, p.refreshes)
+	}
+	if third, err := a.Token(ctx); err != nil || third != second {
+		t.Errorf("Token after refresh = %v, want the refreshed token", err)
+	}
+}
+
+func TestAuthenticatorRefreshRejected(t *testing.T) {
+	ctx := context.Background()
+
+	// A failed refresh while the access token is still valid
+	// keeps the access token.
+	p := newCountingPDS(time.Minute)
+	p.refreshStatus = http.StatusBadRequest
+	a := NewAuthenticator(p.pds().Client(), "alice.test", "secret")
+	first, err := a.Token(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		tok, err := a.Token(ctx)
+		if err != nil || tok != first {
+			t.Errorf("Token after a failed refresh = %v, want the still-valid token", err)
+		}
+	}
+	if p.logins != 1 || p.refreshes != 3 {
+		t.Errorf("logins, refreshes = %d, %d; want 1, 3", p.logins, p.refreshes)
+	}
+
+	// Once the access token has expired, a failed refresh logs in again.
+	a.sess = appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, time.Now().Add(-time.Minute))
+	p.exp = func() time.Time { return time.Now().Add(time.Hour) }
+	if _, err := a.Token(ctx); err != nil {
+		t.Errorf("Token with an expired access token = %v", err)
+	}
+	if p.logins != 2 || p.refreshes != 4 {
+		t.Errorf("logins, refreshes = %d, %d; want 2, 4", p.logins, p.refreshes)
+	}
+
+	// A refresh token rejected as unauthorized logs in again at once.
+	p = newCountingPDS(time.Minute)
+	p.refreshStatus = http.StatusUnauthorized
+	a = NewAuthenticator(p.pds().Client(), "alice.test", "secret")
+	if _, err := a.Token(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Token(ctx); err != nil {
+		t.Errorf("Token after a rejected refresh = %v", err)
+	}
+	if p.logins != 2 || p.refreshes != 1 {
+		t.Errorf("logins, refreshes = %d, %d; want 2, 1", p.logins, p.refreshes)
+	}
+}
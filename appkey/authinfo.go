@@ -0,0 +1,40 @@
+package appkey
+
+import (
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// CheckAuthInfo validates auth, as used by xrpc.Client and commonly
+// persisted by indigo-based tools, in the same way that Check validates
+// the output of createSession.
+func CheckAuthInfo(auth *xrpc.AuthInfo, opts ...Option) error {
+	if auth == nil {
+		return ErrNilSession
+	}
+	return Check(fromAuthInfo(auth), opts...)
+}
+
+// fromAuthInfo converts auth to the equivalent createSession output.
+func fromAuthInfo(auth *xrpc.AuthInfo) *atproto.ServerCreateSession_Output {
+	return &atproto.ServerCreateSession_Output{
+		AccessJwt:  auth.AccessJwt,
+		RefreshJwt: auth.RefreshJwt,
+		Did:        auth.Did,
+		Handle:     auth.Handle,
+	}
+}
+
+// ToAuthInfo validates sess with Check and returns the equivalent
+// xrpc.AuthInfo, suitable for setting as the Auth of an xrpc.Client.
+func ToAuthInfo(sess *atproto.ServerCreateSession_Output, opts ...Option) (*xrpc.AuthInfo, error) {
+	if err := Check(sess, opts...); err != nil {
+		return nil, err
+	}
+	return &xrpc.AuthInfo{
+		AccessJwt:  sess.AccessJwt,
+		RefreshJwt: sess.RefreshJwt,
+		Handle:     sess.Handle,
+		Did:        sess.Did,
+	}, nil
+}
@@ -0,0 +1,69 @@
+package appkey
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// BackoffOptions configures the retries performed by LoginWithBackoff.
+// The zero value uses the defaults documented for each field.
+type BackoffOptions struct {
+	// MaxAttempts is the total number of login attempts, including the first.
+	// If zero, 5 attempts are made.
+	MaxAttempts int
+
+	// InitialDelay is the delay before the first retry. If zero, one second is used.
+	InitialDelay time.Duration
+
+	// MaxDelay caps the delay between attempts. If zero, one minute is used.
+	MaxDelay time.Duration
+}
+
+// LoginWithBackoff is like Login, but retries failures for which
+// IsRetryable reports true, such as rate limits and 5xx responses from
+// createSession. The delay between attempts doubles after each attempt,
+// up to opts.MaxDelay, and is randomized to avoid synchronized retries.
+// Authentication failures, including ErrMasterCredentials and
+// ErrLoginUnauthorized, are never retried.
+// LoginWithBackoff stops early if ctx is done, returning ctx.Err().
+func LoginWithBackoff(ctx context.Context, client *xrpc.Client, identifier, password string, opts BackoffOptions) (*atproto.ServerCreateSession_Output, error) {
+	attempts := opts.MaxAttempts
+	if attempts <= 0 {
+		attempts = 5
+	}
+	delay := opts.InitialDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	maxDelay := opts.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = time.Minute
+	}
+
+	for attempt := 1; ; attempt++ {
+		sess, err := Login(ctx, client, identifier, password)
+		if err == nil || attempt >= attempts || !IsRetryable(err) {
+			return sess, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		// Sleep for a random duration between half and all of the delay.
+		d := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+		if delay *= 2; delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package appkey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// CheckAll runs Check on each of sessions with opts and returns the results
+// in the same order. A nil session results in ErrNilSession.
+func CheckAll(sessions []*atproto.ServerCreateSession_Output, opts ...Option) []error {
+	errs := make([]error, len(sessions))
+	for i, sess := range sessions {
+		errs[i] = Check(sess, opts...)
+	}
+	return errs
+}
+
+// Partition runs Check on each of sessions with opts and sorts them by outcome,
+// as classified by Classify: live sessions passed validation, expired
+// sessions are CategoryExpired, master sessions were created with a master
+// password, and invalid sessions failed for any other reason, including
+// nil sessions. The order of sessions within each bucket is preserved.
+func Partition(sessions []*atproto.ServerCreateSession_Output, opts ...Option) (live, expired, master, invalid []*atproto.ServerCreateSession_Output) {
+	for i, err := range CheckAll(sessions, opts...) {
+		switch Classify(err) {
+		case CategoryOK:
+			live = append(live, sessions[i])
+		case CategoryExpired:
+			expired = append(expired, sessions[i])
+		case CategoryMasterCredentials:
+			master = append(master, sessions[i])
+		default:
+			invalid = append(invalid, sessions[i])
+		}
+	}
+	return live, expired, master, invalid
+}
+
+// ExpiringWithin returns those of sessions whose access token expires within
+// window, including any that have already expired, in their original order.
+// As with ShouldRefresh, only the expiry of each access token is examined.
+// Sessions whose expiry cannot be determined, such as nil or malformed
+// sessions, are left out of the result and reported in the returned error,
+// which joins an error for each of them naming its index. The other
+// sessions are still examined.
+func ExpiringWithin(sessions []*atproto.ServerCreateSession_Output, window time.Duration) ([]*atproto.ServerCreateSession_Output, error) {
+	var expiring []*atproto.ServerCreateSession_Output
+	var errs []error
+	for i, sess := range sessions {
+		soon, err := ShouldRefresh(sess, window)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("session %d: %w", i, err))
+			continue
+		}
+		if soon {
+			expiring = append(expiring, sess)
+		}
+	}
+	return expiring, errors.Join(errs...)
+}
+
+// CheckMany is like CheckAll, but checks up to concurrency sessions at a time,
+// and stops early if ctx is canceled. If concurrency is less than 1,
+// runtime.GOMAXPROCS(0) is used.
+//
+// The returned slice holds the result for each session, aligned by index.
+// Sessions not checked because ctx was canceled have ctx.Err() as their result,
+// and ctx.Err() is also returned as the second result.
+func CheckMany(ctx context.Context, sessions []*atproto.ServerCreateSession_Output, concurrency int, opts ...Option) ([]error, error) {
+	if concurrency < 1 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	errs := make([]error, len(sessions))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < len(sessions); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = CheckContext(ctx, sessions[i], opts...)
+			}
+		}()
+	}
+
+feed:
+	for i := range sessions {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			for ; i < len(sessions); i++ {
+				errs[i] = ctx.Err()
+			}
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return errs, ctx.Err()
+}
+
+// CheckTokenPairs is like CheckAll, but accepts (access, refresh) token
+// pairs, as with CheckTokens. Each result describes what was learned about
+// the pair, as with CheckDetailed, and holds its validation error in Err.
+// All pairs are checked against the same time.
+func CheckTokenPairs(pairs [][2]string, opts ...Option) []CheckResult {
+	results := make([]CheckResult, len(pairs))
+	cfg := newConfig(opts)
+	for i, pair := range pairs {
+		res, err := cfg.observe(nil, func() (CheckResult, error) { return inspectTokens(pair[0], pair[1], cfg) })
+		res.Err = err
+		results[i] = res
+	}
+	return results
+}
+
+// CheckStream reads newline-delimited JSON createSession responses from r,
+// such as an audit file of stored sessions, and calls fn with each session
+// and the result of validating it with opts as by CheckJSON. A record that
+// cannot be decoded as a session is reported to fn with a nil session and a
+// decoding error, and reading continues. Records are processed one at a time,
+// so the input is never held in memory in full.
+//
+// CheckStream returns nil once r is exhausted. It returns an error if r
+// cannot be read or does not contain valid JSON, or ctx.Err() if ctx is
+// canceled before the end of the input.
+func CheckStream(ctx context.Context, r io.Reader, fn func(sess *atproto.ServerCreateSession_Output, err error), opts ...Option) error {
+	dec := json.NewDecoder(r)
+	for n := 1; ; n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("reading session %d: %w", n, err)
+		}
+		var sess atproto.ServerCreateSession_Output
+		var extra sessionExtras
+		if err := json.Unmarshal(raw, &sess); err != nil {
+			fn(nil, fmt.Errorf("decoding session %d: %w", n, err))
+			continue
+		}
+		if err := json.Unmarshal(raw, &extra); err != nil {
+			fn(nil, fmt.Errorf("decoding session %d: %w", n, err))
+			continue
+		}
+		cfg := newConfig(opts)
+		cfg.ctx = ctx
+		cfg.extra = extra
+		_, err := inspect(&sess, cfg)
+		fn(&sess, err)
+	}
+}
@@ -0,0 +1,221 @@
+package appkey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/thepudds/bluesky-aux/appkey/appkeytest"
+)
+
+func TestBatchOptions(t *testing.T) {
+	exp := time.Unix(1700000000, 0)
+	before, after := WithNow(exp.Add(-time.Hour)), WithNow(exp.Add(time.Hour))
+	sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp)
+	sessions := []*atproto.ServerCreateSession_Output{sess}
+
+	if errs := CheckAll(sessions, before); errs[0] != nil {
+		t.Errorf("CheckAll before expiry = %v, want nil", errs[0])
+	}
+	if errs := CheckAll(sessions, after); !errors.Is(errs[0], ErrSessionExpired) {
+		t.Errorf("CheckAll after expiry = %v, want ErrSessionExpired", errs[0])
+	}
+	if errs := CheckAll(sessions, after, WithLeeway(2*time.Hour)); errs[0] != nil {
+		t.Errorf("CheckAll with leeway = %v, want nil", errs[0])
+	}
+	if _, expired, _, _ := Partition(sessions, after); len(expired) != 1 {
+		t.Errorf("Partition after expiry: %d expired, want 1", len(expired))
+	}
+	if errs, err := CheckMany(context.Background(), sessions, 1, after); err != nil || !errors.Is(errs[0], ErrSessionExpired) {
+		t.Errorf("CheckMany after expiry = %v, %v; want ErrSessionExpired", errs, err)
+	}
+	pairs := [][2]string{{sess.AccessJwt, sess.RefreshJwt}}
+	if res := CheckTokenPairs(pairs, before); res[0].Err != nil {
+		t.Errorf("CheckTokenPairs before expiry = %v, want nil", res[0].Err)
+	}
+	if res := CheckTokenPairs(pairs, after); !errors.Is(res[0].Err, ErrSessionExpired) {
+		t.Errorf("CheckTokenPairs after expiry = %v, want ErrSessionExpired", res[0].Err)
+	}
+
+	line, err := json.Marshal(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streamErr error
+	err = CheckStream(context.Background(), strings.NewReader(string(line)), func(_ *atproto.ServerCreateSession_Output, err error) {
+		streamErr = err
+	}, after)
+	if err != nil || !errors.Is(streamErr, ErrSessionExpired) {
+		t.Errorf("CheckStream after expiry = %v, %v; want ErrSessionExpired", err, streamErr)
+	}
+
+	if err := CheckUntil(sess, exp.Add(-time.Minute), before); err != nil {
+		t.Errorf("CheckUntil before expiry = %v, want nil", err)
+	}
+	if err := CheckUntil(sess, exp.Add(-time.Minute), after); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("CheckUntil after expiry = %v, want ErrSessionExpired", err)
+	}
+	if _, err := CheckWithDriftReport(sess, exp.Add(time.Hour), WithLeeway(2*time.Hour)); err != nil {
+		t.Errorf("CheckWithDriftReport with leeway = %v, want nil", err)
+	}
+	if _, err := CheckWithDriftReport(sess, exp.Add(time.Hour), before); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("CheckWithDriftReport = %v, want trustedNow to override WithNow", err)
+	}
+
+	master := appkeytest.NewSession(testDID, "alice.test", appkeytest.MasterScope, exp)
+	if reauth, err := NeedsReauth(master, before); err != nil || !reauth {
+		t.Errorf("NeedsReauth before expiry = %v, %v; want true", reauth, err)
+	}
+	if _, err := NeedsReauth(master, after); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("NeedsReauth after expiry = %v, want ErrSessionExpired", err)
+	}
+	lapsed := WithNow(exp.Add(appkeytest.RefreshLifetime))
+	if action, wait, err := NextAction(sess, 10*time.Minute, before); err != nil || action != ActionRefresh || wait != 50*time.Minute {
+		t.Errorf("NextAction before expiry = %v, %v, %v; want refresh in 50m", action, wait, err)
+	}
+	if action, _, err := NextAction(sess, 10*time.Minute, lapsed); err != nil || action != ActionReauth {
+		t.Errorf("NextAction after refresh expiry = %v, %v; want reauth", action, err)
+	}
+	if action, _, err := NextAction(sess, 0, before, WithScopes("com.example.other")); err != nil || action != ActionReauth {
+		t.Errorf("NextAction with unaccepted scope = %v, %v; want reauth", action, err)
+	}
+}
+
+func TestCheckMany(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	var sessions []*atproto.ServerCreateSession_Output
+	for i := 0; i < 50; i++ {
+		switch i % 3 {
+		case 0:
+			sessions = append(sessions, appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp))
+		case 1:
+			sessions = append(sessions, appkeytest.NewSession(testDID, "alice.test", appkeytest.MasterScope, exp))
+		case 2:
+			sessions = append(sessions, nil)
+		}
+	}
+	errs, err := CheckMany(context.Background(), sessions, 4)
+	if err != nil {
+		t.Fatalf("CheckMany: %v", err)
+	}
+	if len(errs) != len(sessions) {
+		t.Fatalf("CheckMany returned %d results, want %d", len(errs), len(sessions))
+	}
+	for i, got := range errs {
+		if want := Check(sessions[i]); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("result %d = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestCheckManyCanceled(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	sessions := make([]*atproto.ServerCreateSession_Output, 100)
+	for i := range sessions {
+		sessions[i] = appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Cancel as soon as the first session has been checked.
+	cancelOnCheck := WithOptions(CheckOptions{Observer: func(Category) { cancel() }})
+	errs, err := CheckMany(ctx, sessions, 1, cancelOnCheck)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("CheckMany = %v, want context.Canceled", err)
+	}
+	if errs[0] != nil {
+		t.Errorf("result 0 = %v, want nil", errs[0])
+	}
+	canceled := 0
+	for i, err := range errs {
+		switch {
+		case errors.Is(err, context.Canceled):
+			canceled++
+		case err != nil:
+			t.Errorf("result %d = %v, want nil or context.Canceled", i, err)
+		}
+	}
+	if canceled == 0 {
+		t.Error("no sessions were skipped after cancellation")
+	}
+	if !errors.Is(errs[len(errs)-1], context.Canceled) {
+		t.Errorf("last result = %v, want context.Canceled", errs[len(errs)-1])
+	}
+}
+
+func TestCheckStream(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	good, err := json.Marshal(appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, err := json.Marshal(appkeytest.NewSession(testDID, "alice.test", appkeytest.MasterScope, exp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := strings.Join([]string{string(good), `{"accessJwt":5}`, `[1,2]`, string(master), string(good)}, "\n")
+
+	type record struct {
+		sess bool
+		err  error
+	}
+	var got []record
+	err = CheckStream(context.Background(), strings.NewReader(input), func(sess *atproto.ServerCreateSession_Output, err error) {
+		got = append(got, record{sess != nil, err})
+	})
+	if err != nil {
+		t.Fatalf("CheckStream: %v", err)
+	}
+	if len(got) != 5 {
+		t.Fatalf("CheckStream reported %d records, want 5", len(got))
+	}
+	for i, r := range got {
+		malformed := i == 1 || i == 2
+		if r.sess == malformed {
+			t.Errorf("record %d: session reported = %v, want %v", i+1, r.sess, !malformed)
+		}
+		switch {
+		case malformed:
+			if r.err == nil || !strings.Contains(r.err.Error(), fmt.Sprintf("decoding session %d", i+1)) {
+				t.Errorf("record %d: err = %v, want a decoding error naming it", i+1, r.err)
+			}
+		case i == 3:
+			if !errors.Is(r.err, ErrMasterCredentials) {
+				t.Errorf("record %d: err = %v, want ErrMasterCredentials", i+1, r.err)
+			}
+		case r.err != nil:
+			t.Errorf("record %d: err = %v, want nil", i+1, r.err)
+		}
+	}
+
+	// Input that is not JSON stops the stream.
+	calls := 0
+	err = CheckStream(context.Background(), strings.NewReader(string(good)+"\nnot json\n"+string(good)), func(*atproto.ServerCreateSession_Output, error) {
+		calls++
+	})
+	if err == nil || !strings.Contains(err.Error(), "reading session 2") || calls != 1 {
+		t.Errorf("CheckStream of invalid JSON = %v after %d records, want a reading error after 1", err, calls)
+	}
+}
+
+func TestCheckStreamCanceled(t *testing.T) {
+	good, err := json.Marshal(appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := strings.Repeat(string(good)+"\n", 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	err = CheckStream(ctx, strings.NewReader(input), func(*atproto.ServerCreateSession_Output, error) {
+		calls++
+		cancel()
+	})
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Errorf("CheckStream = %v after %d records, want context.Canceled after 1", err, calls)
+	}
+}
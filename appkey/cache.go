@@ -0,0 +1,114 @@
+package appkey
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// CachedChecker performs the same validation as Check, but remembers
+// sessions that passed so that repeated checks of the same session only
+// compare the remembered expiries against the current time.
+// Entries are evicted once the session expires.
+//
+// A CachedChecker is safe for concurrent use. The zero value is ready to use.
+type CachedChecker struct {
+	cache sessionCache
+}
+
+// Check validates sess like Check, using a remembered result if available.
+func (c *CachedChecker) Check(sess *atproto.ServerCreateSession_Output) error {
+	return c.cache.check(sess, checkConfig{now: time.Now()}, 0)
+}
+
+// sweepInterval is the minimum time between sweeps of a sessionCache
+// for expired entries.
+const sweepInterval = time.Minute
+
+// sessionCache remembers sessions that passed validation until a deadline.
+// It is the shared implementation of CachedChecker and TTLCache.
+// Expired entries are evicted when they are looked up, and the whole cache
+// is swept for them at most once per sweepInterval, so that entries that
+// are never looked up again do not accumulate.
+//
+// The zero value is ready to use.
+type sessionCache struct {
+	mu        sync.Mutex
+	entries   map[[sha256.Size]byte]time.Time // session key to deadline
+	lastSweep time.Time
+}
+
+// check validates sess with cfg, unless it is remembered as valid at
+// cfg.now. A session that passes is remembered until either of its tokens
+// expires or, if ttl is positive, until ttl has elapsed. A non-positive ttl
+// means the session is only bounded by its expiry. Once its deadline
+// passes, a session is validated again.
+func (c *sessionCache) check(sess *atproto.ServerCreateSession_Output, cfg checkConfig, ttl time.Duration) error {
+	if sess == nil {
+		return ErrNilSession
+	}
+	key := cacheKey(sess)
+	if c.lookup(key, cfg.now) {
+		return nil
+	}
+
+	// Check outside the lock. Concurrent misses for the same session
+	// may each check it, which is harmless.
+	res, err := inspect(sess, cfg)
+	if err != nil {
+		return err
+	}
+	deadline := res.AccessExpiry
+	if res.RefreshExpiry.Before(deadline) {
+		deadline = res.RefreshExpiry
+	}
+	if ttl > 0 && cfg.now.Add(ttl).Before(deadline) {
+		deadline = cfg.now.Add(ttl)
+	}
+	c.store(key, deadline, cfg.now)
+	return nil
+}
+
+// lookup reports whether key is remembered with a deadline after now,
+// evicting it if its deadline has passed.
+func (c *sessionCache) lookup(key [sha256.Size]byte, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deadline, ok := c.entries[key]
+	if ok && !now.Before(deadline) {
+		delete(c.entries, key)
+		return false
+	}
+	return ok
+}
+
+// store remembers key until deadline, first sweeping the cache for expired
+// entries if it has not been swept within sweepInterval.
+func (c *sessionCache) store(key [sha256.Size]byte, deadline, now time.Time) {
+	if !now.Before(deadline) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[[sha256.Size]byte]time.Time)
+		c.lastSweep = now
+	}
+	if d := now.Sub(c.lastSweep); d >= sweepInterval || d < 0 {
+		for k, d := range c.entries {
+			if !now.Before(d) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.entries[key] = deadline
+}
+
+// cacheKey returns the key for sess, which is a hash of both tokens
+// so that the tokens themselves are not retained as keys.
+func cacheKey(sess *atproto.ServerCreateSession_Output) [sha256.Size]byte {
+	return sha256.Sum256([]byte(sess.AccessJwt + "\x00" + sess.RefreshJwt))
+}
@@ -0,0 +1,61 @@
+package appkey
+
+import (
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// Checker validates sessions. It allows callers to swap in a caching
+// implementation without changing the code that performs the checks.
+type Checker interface {
+	Check(sess *atproto.ServerCreateSession_Output) error
+}
+
+var (
+	_ Checker = DirectChecker{}
+	_ Checker = (*CachedChecker)(nil)
+	_ Checker = (*TTLCache)(nil)
+)
+
+// DirectChecker is a Checker that validates every session with CheckWith,
+// without any caching.
+type DirectChecker struct {
+	Options CheckOptions
+}
+
+// Check validates sess with CheckWith and c.Options.
+func (c DirectChecker) Check(sess *atproto.ServerCreateSession_Output) error {
+	return CheckWith(sess, c.Options)
+}
+
+// TTLCache is a Checker that performs the same validation as Check, and
+// remembers sessions that passed for up to a fixed duration. A remembered
+// session is forgotten once the TTL elapses or either of its tokens expires,
+// whichever comes first, so that an expired session is never accepted.
+//
+// A TTLCache is safe for concurrent use. The zero value is ready to use,
+// but has a zero TTL and so never remembers a session; use NewTTLCache.
+type TTLCache struct {
+	ttl   time.Duration
+	opts  []Option
+	cache sessionCache
+}
+
+// NewTTLCache returns a TTLCache that remembers successful validations for
+// ttl. Sessions are validated with opts, as for Check. The TTL and the token
+// expiries are measured against the validation time, so an option such as
+// WithNow that fixes that time also stops remembered sessions from lapsing.
+func NewTTLCache(ttl time.Duration, opts ...Option) *TTLCache {
+	return &TTLCache{ttl: ttl, opts: opts}
+}
+
+// Check validates sess like Check, using a remembered result if available.
+func (c *TTLCache) Check(sess *atproto.ServerCreateSession_Output) error {
+	cfg := newConfig(c.opts)
+	if c.ttl <= 0 {
+		_, err := inspect(sess, cfg)
+		return err
+	}
+	return c.cache.check(sess, cfg, c.ttl)
+}
@@ -0,0 +1,159 @@
+package appkey
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/thepudds/bluesky-aux/appkey/appkeytest"
+)
+
+func TestTTLCacheZeroValue(t *testing.T) {
+	var c TTLCache
+	sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, time.Now().Add(time.Hour))
+	for i := 0; i < 2; i++ {
+		if err := c.Check(sess); err != nil {
+			t.Errorf("Check #%d: %v", i, err)
+		}
+	}
+	master := appkeytest.NewSession(testDID, "alice.test", appkeytest.MasterScope, time.Now().Add(time.Hour))
+	if err := c.Check(master); !errors.Is(err, ErrMasterCredentials) {
+		t.Errorf("Check = %v, want ErrMasterCredentials", err)
+	}
+}
+
+func TestTTLCache(t *testing.T) {
+	c := NewTTLCache(time.Minute)
+	sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, time.Now().Add(time.Hour))
+	if err := c.Check(sess); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if n := len(c.cache.entries); n != 1 {
+		t.Errorf("after Check, cache has %d entries, want 1", n)
+	}
+	if err := c.Check(sess); err != nil {
+		t.Errorf("Check of a remembered session: %v", err)
+	}
+	if err := c.Check(nil); !errors.Is(err, ErrNilSession) {
+		t.Errorf("Check(nil) = %v, want ErrNilSession", err)
+	}
+}
+
+func TestTTLCacheOptions(t *testing.T) {
+	master := appkeytest.NewSession(testDID, "alice.test", appkeytest.MasterScope, time.Now().Add(time.Hour))
+	c := NewTTLCache(time.Minute, WithOptions(CheckOptions{AllowMasterCredentials: true}))
+	for i := 0; i < 2; i++ {
+		if err := c.Check(master); err != nil {
+			t.Errorf("Check #%d allowing master credentials: %v", i, err)
+		}
+	}
+	lapsed := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, time.Now().Add(-time.Minute))
+	c = NewTTLCache(time.Minute, WithLeeway(time.Hour))
+	if err := c.Check(lapsed); err != nil {
+		t.Errorf("Check with leeway: %v", err)
+	}
+	if n := len(c.cache.entries); n != 0 {
+		t.Errorf("cache remembers %d expired sessions, want 0", n)
+	}
+}
+
+func TestSessionCacheSweep(t *testing.T) {
+	var c sessionCache
+	now := time.Now()
+	c.store([32]byte{1}, now.Add(time.Second), now)
+	c.store([32]byte{2}, now.Add(time.Hour), now)
+	if n := len(c.entries); n != 2 {
+		t.Fatalf("cache has %d entries, want 2", n)
+	}
+	// Expired entries are only swept once sweepInterval has passed.
+	later := now.Add(sweepInterval / 2)
+	c.store([32]byte{3}, later.Add(time.Hour), later)
+	if n := len(c.entries); n != 3 {
+		t.Errorf("cache swept after %v, has %d entries, want 3", sweepInterval/2, len(c.entries))
+	}
+	if c.lookup([32]byte{1}, later) {
+		t.Error("lookup of an expired entry succeeded")
+	}
+	if n := len(c.entries); n != 2 {
+		t.Errorf("lookup did not evict the expired entry, cache has %d entries", n)
+	}
+	c.store([32]byte{4}, later.Add(time.Second), later)
+	swept := now.Add(sweepInterval)
+	c.store([32]byte{5}, swept.Add(time.Hour), swept)
+	if _, ok := c.entries[[32]byte{4}]; ok || len(c.entries) != 3 {
+		t.Errorf("after a sweep, cache has %d entries, want 3 live ones", len(c.entries))
+	}
+	if !c.lookup([32]byte{2}, swept) {
+		t.Error("lookup of a live entry failed")
+	}
+}
+
+func TestCachedChecker(t *testing.T) {
+	var c CachedChecker
+	sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, time.Now().Add(time.Hour))
+	if err := c.Check(sess); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if n := len(c.cache.entries); n != 1 {
+		t.Errorf("after Check, cache has %d entries, want 1", n)
+	}
+	master := appkeytest.NewSession(testDID, "alice.test", appkeytest.MasterScope, time.Now().Add(time.Hour))
+	if err := c.Check(master); !errors.Is(err, ErrMasterCredentials) {
+		t.Errorf("Check = %v, want ErrMasterCredentials", err)
+	}
+	if n := len(c.cache.entries); n != 1 {
+		t.Errorf("after a failed Check, cache has %d entries, want 1", n)
+	}
+
+	// A remembered result is used without validating the session again,
+	// until it expires.
+	key := cacheKey(master)
+	c.cache.mu.Lock()
+	c.cache.entries[key] = time.Now().Add(time.Hour)
+	c.cache.mu.Unlock()
+	if err := c.Check(master); err != nil {
+		t.Errorf("Check of a remembered session = %v, want nil", err)
+	}
+	c.cache.mu.Lock()
+	c.cache.entries[key] = time.Now().Add(-time.Second)
+	c.cache.mu.Unlock()
+	if err := c.Check(master); !errors.Is(err, ErrMasterCredentials) {
+		t.Errorf("Check of an expired entry = %v, want ErrMasterCredentials", err)
+	}
+	if _, ok := c.cache.entries[key]; ok {
+		t.Error("expired entry was not evicted")
+	}
+	if err := c.Check(nil); !errors.Is(err, ErrNilSession) {
+		t.Errorf("Check(nil) = %v, want ErrNilSession", err)
+	}
+}
+
+func TestCachedCheckerConcurrent(t *testing.T) {
+	var c CachedChecker
+	exp := time.Now().Add(time.Hour)
+	sessions := []*atproto.ServerCreateSession_Output{
+		appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp),
+		appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp.Add(time.Minute)),
+		appkeytest.NewSession(testDID, "alice.test", appkeytest.MasterScope, exp),
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				sess := sessions[(i+j)%len(sessions)]
+				if got, want := c.Check(sess), Check(sess); fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Errorf("Check = %v, want %v", got, want)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := len(c.cache.entries); n != 2 {
+		t.Errorf("cache has %d entries, want 2", n)
+	}
+}
@@ -0,0 +1,220 @@
+package appkey
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims holds the standard claims of a Bluesky session token.
+// Claims that are absent from the token are left as their zero values.
+type Claims struct {
+	// Scope is the scope claim, such as "com.atproto.appPass".
+	Scope string
+
+	// Subject is the sub claim, which is the DID of the account.
+	Subject string
+
+	// Audience is the aud claim, which typically identifies the PDS by its DID.
+	Audience []string
+
+	// Issuer is the iss claim.
+	Issuer string
+
+	// IssuedAt, NotBefore, and ExpiresAt are the iat, nbf, and exp claims.
+	IssuedAt  time.Time
+	NotBefore time.Time
+	ExpiresAt time.Time
+}
+
+// ParseClaims parses tokenString without verifying its signature
+// and returns its standard claims.
+func ParseClaims(tokenString string) (Claims, error) {
+	claims, err := parseClaims(tokenString)
+	if err != nil {
+		return Claims{}, err
+	}
+	return checkConfig{}.claimsFrom(claims)
+}
+
+// ParseAccess parses accessJwt without verifying its signature and
+// returns its standard claims. Only the structure of the token is validated:
+// no time based checks are performed and no network access is required,
+// which makes ParseAccess suitable for fuzzing. Check uses ParseAccess internally.
+func ParseAccess(accessJwt string) (Claims, error) {
+	c, _, err := parseAccess(accessJwt, checkConfig{})
+	return c, err
+}
+
+// parseAccess is like ParseAccess, but parses with the parser configured by cfg.
+// It also returns the token header.
+func parseAccess(accessJwt string, cfg checkConfig) (Claims, map[string]interface{}, error) {
+	if accessJwt == "" {
+		return Claims{}, nil, fmt.Errorf("%w: empty access token", ErrMissingAccessToken)
+	}
+	token, claims, err := cfg.parseToken(accessJwt)
+	if err != nil {
+		return Claims{}, nil, fmt.Errorf("parsing access token: %w", err)
+	}
+	c, err := cfg.accessClaims(claims)
+	if err != nil {
+		return Claims{}, nil, err
+	}
+	return c, token.Header, nil
+}
+
+// accessClaims extracts the standard claims from the claims of an
+// access token, rejecting OAuth tokens, tokens that lack any of
+// cfg.RequiredClaims, revoked tokens, and tokens older than cfg.MinTokenVersion.
+func (cfg checkConfig) accessClaims(claims jwt.MapClaims) (Claims, error) {
+	if isOAuthToken(claims) {
+		return Claims{}, fmt.Errorf("%w: OAuth access token", ErrUnsupportedTokenType)
+	}
+	for _, check := range []func(jwt.MapClaims) error{cfg.checkRequiredClaims, cfg.checkRevoked, cfg.checkTokenVersion} {
+		if err := check(claims); err != nil {
+			if err := cfg.report(fmt.Errorf("access token: %w", err)); err != nil {
+				return Claims{}, err
+			}
+		}
+	}
+	c, err := cfg.claimsFrom(claims)
+	if err != nil {
+		return Claims{}, fmt.Errorf("parsing access token: %w", err)
+	}
+	return c, nil
+}
+
+// claimsFrom extracts the standard claims from claims.
+// The exp claim is interpreted according to cfg.LenientDates.
+func (cfg checkConfig) claimsFrom(claims jwt.MapClaims) (Claims, error) {
+	var c Claims
+	var err error
+	if raw, ok := claims["scope"]; ok && raw != nil {
+		if c.Scope, ok = raw.(string); !ok {
+			return c, fmt.Errorf("%w: unexpected type for scope claim: %T", ErrMalformedToken, raw)
+		}
+	}
+	if c.Subject, err = claims.GetSubject(); err != nil {
+		return c, fmt.Errorf("%w: %w", ErrMalformedToken, err)
+	}
+	if c.Audience, err = claims.GetAudience(); err != nil {
+		return c, fmt.Errorf("%w: %w", ErrMalformedToken, err)
+	}
+	if c.Issuer, err = claims.GetIssuer(); err != nil {
+		return c, fmt.Errorf("%w: %w", ErrMalformedToken, err)
+	}
+	iat, err := claims.GetIssuedAt()
+	if err != nil {
+		return c, fmt.Errorf("%w: %w", ErrMalformedToken, err)
+	}
+	if iat != nil {
+		c.IssuedAt = iat.Time
+	}
+	nbf, err := claims.GetNotBefore()
+	if err != nil {
+		return c, fmt.Errorf("%w: %w", ErrMalformedToken, err)
+	}
+	if nbf != nil {
+		c.NotBefore = nbf.Time
+	}
+	c.ExpiresAt, err = cfg.expiration(claims)
+	if err != nil && !errors.Is(err, ErrMissingExpiration) {
+		return c, err
+	}
+	return c, nil
+}
+
+// Audience returns the aud claim of accessJwt, which identifies the PDS
+// that issued the token. The aud claim may be either a string or an array
+// of strings. For an array, the first element is returned; use Audiences
+// to retrieve all of them.
+// An empty string is returned if the token has no aud claim, and
+// ErrAudienceNotDID if any of its values is not a DID.
+func Audience(accessJwt string) (string, error) {
+	claims, err := parseClaims(accessJwt)
+	if err != nil {
+		return "", err
+	}
+	aud, err := claims.GetAudience()
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrMalformedToken, err)
+	}
+	if err := checkAudienceDIDs(aud); err != nil {
+		return "", err
+	}
+	return first(aud), nil
+}
+
+// Audiences returns all values of the aud claim of accessJwt, which may be
+// either a string or an array of strings. As with Audience, every value
+// must be a DID.
+func Audiences(accessJwt string) ([]string, error) {
+	claims, err := parseClaims(accessJwt)
+	if err != nil {
+		return nil, err
+	}
+	aud, err := claims.GetAudience()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedToken, err)
+	}
+	if err := checkAudienceDIDs(aud); err != nil {
+		return nil, err
+	}
+	return aud, nil
+}
+
+// checkAudienceDIDs confirms that each of the aud values is a DID rather
+// than, for example, a hostname mistakenly used by a misconfigured issuer.
+func checkAudienceDIDs(aud []string) error {
+	for _, a := range aud {
+		if !strings.HasPrefix(a, "did:") {
+			return fmt.Errorf("%w: %q", ErrAudienceNotDID, a)
+		}
+	}
+	return nil
+}
+
+// first returns the first element of s, or an empty string if s is empty.
+func first(s []string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	return s[0]
+}
+
+// parseClaims parses a JWT without verifying its signature
+// and returns its claims. Padded base64url segments are tolerated for
+// interoperability with non-indigo clients. Other decoding problems,
+// such as segments using the standard rather than the url-safe alphabet,
+// are reported as ErrMalformedToken.
+func parseClaims(tokenString string) (jwt.MapClaims, error) {
+	return parseClaimsWith(newParser(), tokenString)
+}
+
+// parseClaimsWith is like parseClaims, but uses parser.
+func parseClaimsWith(parser *jwt.Parser, tokenString string) (jwt.MapClaims, error) {
+	_, claims, err := parseTokenWith(parser, tokenString)
+	return claims, err
+}
+
+// parseTokenWith parses a JWT with parser without verifying its signature,
+// and returns the token, which includes its header, along with its claims.
+func parseTokenWith(parser *jwt.Parser, tokenString string) (*jwt.Token, jwt.MapClaims, error) {
+	token, _, err := parser.ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrMalformedToken, err)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: unexpected type for claims: %T", ErrMalformedToken, token.Claims)
+	}
+	return token, claims, nil
+}
+
+// newParser returns the jwt.Parser used throughout this package.
+func newParser(opts ...jwt.ParserOption) *jwt.Parser {
+	return jwt.NewParser(append([]jwt.ParserOption{jwt.WithPaddingAllowed()}, opts...)...)
+}
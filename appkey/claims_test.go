@@ -0,0 +1,174 @@
+package appkey
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/thepudds/bluesky-aux/appkey/appkeytest"
+)
+
+// padSegments adds base64 padding to each segment of tok.
+func padSegments(tok string) string {
+	segs := strings.Split(tok, ".")
+	for i, s := range segs {
+		segs[i] = s + strings.Repeat("=", (4-len(s)%4)%4)
+	}
+	return strings.Join(segs, ".")
+}
+
+func TestPaddedToken(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp)
+	sess.AccessJwt = padSegments(sess.AccessJwt)
+	sess.RefreshJwt = padSegments(sess.RefreshJwt)
+	if !strings.Contains(sess.AccessJwt, "=") {
+		t.Fatalf("test token %q has no padding", sess.AccessJwt)
+	}
+	if err := Check(sess); err != nil {
+		t.Errorf("Check with padded tokens: %v", err)
+	}
+	c, err := ParseClaims(sess.AccessJwt)
+	if err != nil || c.Subject != testDID {
+		t.Errorf("ParseClaims = %+v, %v", c, err)
+	}
+	if got, err := FastExpiry(sess.AccessJwt); err != nil || got.Unix() != exp.Unix() {
+		t.Errorf("FastExpiry = %v, %v; want %v", got, err, exp)
+	}
+}
+
+func TestStandardAlphabetToken(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	// The note claim encodes to a payload containing both - and _,
+	// which the standard alphabet writes as + and /.
+	access := appkeytest.AppPass(testDID, exp)
+	access.Claims = map[string]any{"note": "???>>>"}
+	tok := access.Encode()
+	header, rest, _ := strings.Cut(tok, ".")
+	payload, sig, _ := strings.Cut(rest, ".")
+	if !strings.ContainsAny(payload, "-_") {
+		t.Fatalf("test payload %q has no URL-safe characters", payload)
+	}
+	payload = strings.NewReplacer("-", "+", "_", "/").Replace(payload)
+	tok = header + "." + payload + "." + sig
+
+	sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp)
+	sess.AccessJwt = tok
+	if err := Check(sess); !errors.Is(err, ErrMalformedToken) {
+		t.Errorf("Check = %v, want ErrMalformedToken", err)
+	}
+	if _, err := ParseClaims(tok); !errors.Is(err, ErrMalformedToken) {
+		t.Errorf("ParseClaims = %v, want ErrMalformedToken", err)
+	}
+}
+
+func FuzzParseAccess(f *testing.F) {
+	exp := time.Unix(1700000000, 0)
+	f.Add(appkeytest.AppPass(testDID, exp).Encode())
+	f.Add(appkeytest.Master(testDID, exp).Encode())
+	f.Add(appkeytest.Token{Scope: appkeytest.AppPassScope, Audience: "did:web:pds.example.com", IssuedAt: exp.Add(-time.Hour)}.Encode())
+	f.Add(padSegments(appkeytest.Refresh(testDID, exp).Encode()))
+	f.Add("")
+	f.Add("a.b.c")
+	f.Add("e30.e30.")
+	f.Fuzz(func(t *testing.T, tok string) {
+		c, err := ParseAccess(tok)
+		if err != nil {
+			if len(tok) >= 16 && strings.Contains(err.Error(), tok) {
+				t.Errorf("ParseAccess error contains the token: %v", err)
+			}
+			return
+		}
+		// A token that parses must parse the same way again.
+		c2, err := ParseAccess(tok)
+		if err != nil || c2.Scope != c.Scope || c2.Subject != c.Subject || !c2.ExpiresAt.Equal(c.ExpiresAt) {
+			t.Errorf("ParseAccess(%q) is not deterministic: %+v, then %+v, %v", tok, c, c2, err)
+		}
+	})
+}
+
+func TestAudienceStringOrArray(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	const pds, other = "did:web:pds.example.com", "did:web:other.example.com"
+	withAud := func(aud any) *atproto.ServerCreateSession_Output {
+		access := appkeytest.AppPass(testDID, exp)
+		if aud != nil {
+			access.Claims = map[string]any{"aud": aud}
+		}
+		sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp)
+		sess.AccessJwt = access.Encode()
+		return sess
+	}
+	tests := []struct {
+		name      string
+		aud       any
+		first     string
+		all       []string
+		wantCheck error
+	}{
+		{"string", pds, pds, []string{pds}, nil},
+		{"array", []string{other, pds}, other, []string{other, pds}, nil},
+		{"single-element array", []string{pds}, pds, []string{pds}, nil},
+		{"array without pds", []string{other}, other, []string{other}, ErrWrongAudience},
+		{"absent", nil, "", nil, ErrWrongAudience},
+	}
+	for _, tt := range tests {
+		sess := withAud(tt.aud)
+		first, err := Audience(sess.AccessJwt)
+		if err != nil || first != tt.first {
+			t.Errorf("%s: Audience = %q, %v; want %q", tt.name, first, err, tt.first)
+		}
+		all, err := Audiences(sess.AccessJwt)
+		if err != nil || strings.Join(all, " ") != strings.Join(tt.all, " ") {
+			t.Errorf("%s: Audiences = %q, %v; want %q", tt.name, all, err, tt.all)
+		}
+		err = CheckAudience(sess, pds)
+		if (tt.wantCheck == nil) != (err == nil) || !errors.Is(err, tt.wantCheck) {
+			t.Errorf("%s: CheckAudience = %v, want %v", tt.name, err, tt.wantCheck)
+		}
+	}
+
+	if _, err := Audiences(withAud([]any{pds, 42}).AccessJwt); !errors.Is(err, ErrMalformedToken) {
+		t.Errorf("Audiences with a numeric aud value = %v, want ErrMalformedToken", err)
+	}
+}
+
+func TestAudienceNotDID(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	tests := []struct {
+		aud  any
+		want error
+	}{
+		{"did:plc:ewvi7nxzyoun6zhxrhs64oiz", nil},
+		{"did:web:pds.example.com", nil},
+		{[]string{"did:plc:ewvi7nxzyoun6zhxrhs64oiz", "did:web:pds.example.com"}, nil},
+		{"pds.example.com", ErrAudienceNotDID},
+		{"https://pds.example.com", ErrAudienceNotDID},
+		{[]string{"did:web:pds.example.com", "pds.example.com"}, ErrAudienceNotDID},
+	}
+	for _, tt := range tests {
+		access := appkeytest.Token{Scope: appkeytest.AppPassScope, Subject: testDID, Issuer: appkeytest.PDSDID, IssuedAt: exp.Add(-time.Hour), ExpiresAt: exp, Claims: map[string]any{"aud": tt.aud}}
+		sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp)
+		sess.AccessJwt = access.Encode()
+
+		_, err := Audience(sess.AccessJwt)
+		if !errors.Is(err, tt.want) || (tt.want == nil) != (err == nil) {
+			t.Errorf("Audience with aud %v = %v, want %v", tt.aud, err, tt.want)
+		}
+		_, err = Audiences(sess.AccessJwt)
+		if !errors.Is(err, tt.want) || (tt.want == nil) != (err == nil) {
+			t.Errorf("Audiences with aud %v = %v, want %v", tt.aud, err, tt.want)
+		}
+		err = StrictCheck(sess)
+		if !errors.Is(err, tt.want) || (tt.want == nil) != (err == nil) {
+			t.Errorf("StrictCheck with aud %v = %v, want %v", tt.aud, err, tt.want)
+		}
+		if tt.want != nil {
+			if err := CheckAudience(sess, "did:web:pds.example.com"); !errors.Is(err, ErrAudienceNotDID) || !errors.Is(err, ErrWrongAudience) {
+				t.Errorf("CheckAudience with aud %v = %v, want ErrAudienceNotDID and ErrWrongAudience", tt.aud, err)
+			}
+		}
+	}
+}
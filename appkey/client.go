@@ -8,13 +8,19 @@
 // This logic was extracted from https://github.com/karalabe/go-bluesky,
 // which ultimately will likely be a much better package than this one.
 // This is currently lightly tested end-to-end by https://github.com/thepudds/gomoderate.
+//
+// Errors returned by this package never contain the raw access or refresh
+// tokens, which are bearer credentials. Use SafeString to log a session.
 package appkey
 
 // TODO: confirm no objections from @karalabe
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/bluesky-social/indigo/api/atproto"
@@ -29,48 +35,953 @@ var (
 	// ErrMasterCredentials is returned from a login attempt if the credentials
 	// are valid on the Bluesky server, but they are the user's master password.
 	// Since that is a security malpractice, this library forbids it.
+	// Errors matching ErrMasterCredentials always match ErrLoginUnauthorized as well.
 	ErrMasterCredentials = errors.New("master credentials used")
 
 	// ErrSessionExpired is returned from any API call if the underlying session
 	// has expired and a new login from scratch is required.
 	ErrSessionExpired = errors.New("session expired")
+
+	// ErrMissingAccessToken is returned if the session does not contain
+	// an access token.
+	ErrMissingAccessToken = errors.New("missing access token")
+
+	// ErrMissingRefreshToken is returned if the session does not contain
+	// a refresh token.
+	ErrMissingRefreshToken = errors.New("missing refresh token")
+
+	// ErrDIDMismatch is returned if the DID of a session does not match
+	// the subject of its access token.
+	ErrDIDMismatch = errors.New("session did does not match token subject")
+
+	// ErrMalformedToken is returned if a token cannot be parsed.
+	// The underlying parse error is wrapped and can be retrieved with errors.As.
+	ErrMalformedToken = errors.New("malformed token")
+
+	// ErrMissingScope is returned if an access token has no scope claim.
+	// This is distinct from ErrMasterCredentials, which is returned if the scope
+	// is present but is not an app password scope.
+	ErrMissingScope = errors.New("missing scope claim")
+
+	// ErrUnexpectedRefreshScope is returned if a refresh token does not have
+	// the expected refresh scope, which can indicate swapped or malformed tokens,
+	// or a refresh token issued for another purpose. It never matches
+	// ErrSessionExpired or ErrMasterCredentials, and the scope is checked
+	// before the expiry, so a wrong kind of refresh token is reported as such
+	// even if it has also expired.
+	ErrUnexpectedRefreshScope = errors.New("unexpected refresh token scope")
+
+	// ErrTokenSubjectMismatch is returned if the access and refresh tokens
+	// of a session were issued for different subjects.
+	ErrTokenSubjectMismatch = errors.New("access and refresh token subjects differ")
+
+	// ErrMissingExpiration is returned if a token has no exp claim.
+	ErrMissingExpiration = errors.New("missing exp claim")
+
+	// ErrNilSession is returned if a nil session is provided.
+	ErrNilSession = errors.New("nil session")
+
+	// ErrUnsupportedTokenType is returned for tokens that are not
+	// createSession tokens, such as OAuth access tokens.
+	// Such tokens are not reported as ErrMasterCredentials.
+	ErrUnsupportedTokenType = errors.New("unsupported token type")
+
+	// ErrWrongAudience is returned if a token is not intended for
+	// the expected PDS.
+	ErrWrongAudience = errors.New("wrong token audience")
+
+	// ErrAudienceNotDID is returned if the aud claim of a token holds a
+	// value that is not a DID, such as the hostname of the PDS.
+	// It also matches ErrWrongAudience.
+	ErrAudienceNotDID = fmt.Errorf("%w: audience is not a did", ErrWrongAudience)
+
+	// ErrTokenNotYetValid is returned if a token is used before the time
+	// given by its nbf (not before) claim.
+	ErrTokenNotYetValid = errors.New("token not yet valid")
+
+	// ErrEmailNotConfirmed is returned if CheckOptions.RequireEmailConfirmed
+	// is set and the account's email address is not known to be confirmed.
+	ErrEmailNotConfirmed = errors.New("email not confirmed")
+
+	// ErrWrongIssuer is returned if a token was not issued by
+	// the expected service.
+	ErrWrongIssuer = errors.New("wrong token issuer")
+
+	// ErrIdenticalTokens is returned if the access and refresh tokens of a
+	// session are the same, which indicates a persistence bug.
+	ErrIdenticalTokens = errors.New("access and refresh tokens are identical")
+
+	// ErrPrivilegeUnknown is returned by IsPrivileged if the session's
+	// access token does not indicate whether its app password is privileged.
+	ErrPrivilegeUnknown = errors.New("app password privilege unknown")
+
+	// ErrDIDNotAllowed is returned if CheckOptions.AllowedDIDs is set and
+	// the session is for an account not in the list.
+	ErrDIDNotAllowed = errors.New("did not allowed")
+
+	// ErrTokenRevoked is returned if the jti claim of a token is listed
+	// in CheckOptions.RevokedJTIs.
+	ErrTokenRevoked = errors.New("token revoked")
+
+	// ErrIncompleteSession is returned if a session is missing its access
+	// token, refresh token, or DID, which indicates a deserialization problem.
+	// A missing token also matches ErrMissingAccessToken or ErrMissingRefreshToken.
+	ErrIncompleteSession = errors.New("incomplete session")
+
+	// ErrLifetimeTooLong is returned if CheckOptions.MaxLifetime is set and
+	// the access token is valid for longer than it allows.
+	ErrLifetimeTooLong = errors.New("token lifetime too long")
+
+	// ErrTokenTooOld is returned if CheckOptions.MinTokenVersion is set and
+	// the access token's version claim is absent or older than required.
+	ErrTokenTooOld = errors.New("token version too old")
+
+	// ErrAccountInactive is returned if CheckOptions.RejectInactiveAccounts
+	// is set and the account is not active, such as if it has been
+	// taken down or deactivated.
+	ErrAccountInactive = errors.New("account not active")
+
+	// ErrMalformedSession is returned by ValidateSessionShape if a
+	// createSession response is missing a required field or has a field
+	// of the wrong JSON type.
+	ErrMalformedSession = errors.New("malformed session")
+
+	// ErrSessionTooOld is returned if CheckOptions.MaxAge is set and the
+	// access token was issued longer ago than it allows.
+	ErrSessionTooOld = errors.New("session too old")
 )
 
+// MasterCredentialsError is the error returned when a session was created
+// with a master password. It records the scope claim that was present so it
+// can be logged when diagnosing unusual tokens, and it matches both
+// ErrMasterCredentials and ErrLoginUnauthorized with errors.Is.
+type MasterCredentialsError struct {
+	// Scope is the scope claim of the access token.
+	Scope string
+
+	err error // the error from the scope policy, if any
+}
+
+func (e *MasterCredentialsError) Error() string {
+	msg := ErrMasterCredentials.Error()
+	if e.err != nil {
+		msg = e.err.Error()
+	}
+	return fmt.Sprintf("%v: %s (scope %q)", ErrLoginUnauthorized, msg, e.Scope)
+}
+
+// Is reports whether target is ErrMasterCredentials or ErrLoginUnauthorized.
+func (e *MasterCredentialsError) Is(target error) bool {
+	return target == ErrMasterCredentials || target == ErrLoginUnauthorized
+}
+
+// Unwrap returns the underlying error from the scope policy, if any.
+func (e *MasterCredentialsError) Unwrap() error {
+	return e.err
+}
+
+// SessionExpiredError is the error returned when a token of a session has
+// expired. It matches ErrSessionExpired with errors.Is.
+type SessionExpiredError struct {
+	// AccessExpiry and RefreshExpiry are the expiration times of the
+	// access and refresh tokens, or the zero time if not known.
+	AccessExpiry  time.Time
+	RefreshExpiry time.Time
+
+	// Token is the token that expired, either "access" or "refresh".
+	// If the access token expired but the refresh token has not,
+	// the session can be refreshed.
+	Token string
+}
+
+func (e *SessionExpiredError) Error() string {
+	exp := e.AccessExpiry
+	if e.Token == "refresh" {
+		exp = e.RefreshExpiry
+	}
+	return fmt.Sprintf("%v: %s token expired at %v", ErrSessionExpired, e.Token, exp)
+}
+
+// Is reports whether target is ErrSessionExpired.
+func (e *SessionExpiredError) Is(target error) bool {
+	return target == ErrSessionExpired
+}
+
+// IsMasterCredentials reports whether err indicates that a master password
+// was used rather than an app password.
+func IsMasterCredentials(err error) bool {
+	return errors.Is(err, ErrMasterCredentials)
+}
+
+// appPassScope is the scope claim of an access token created with an app password.
+const appPassScope = "com.atproto.appPass"
+
+// appPassPrivilegedScope is the scope claim of an access token created with
+// a privileged app password, which additionally grants access to direct messages.
+const appPassPrivilegedScope = "com.atproto.appPassPrivileged"
+
+// refreshScope is the scope claim of a refresh token.
+const refreshScope = "com.atproto.refresh"
+
+// takendownScope is the scope claim of an access token issued for an
+// account that has been taken down.
+const takendownScope = "com.atproto.takendown"
+
 // Check ensures an offered Bluesky password is
 // an application key and not a master password, as well as does some
 // additional jwt and time based checks.
-func Check(sess *atproto.ServerCreateSession_Output) error {
-	token, _, err := jwt.NewParser().ParseUnverified(sess.AccessJwt, jwt.MapClaims{})
+// Check is a thin wrapper that calls CheckContext with context.Background().
+// The validation can be adjusted with opts, such as WithNow or WithLeeway.
+func Check(sess *atproto.ServerCreateSession_Output, opts ...Option) error {
+	return CheckContext(context.Background(), sess, opts...)
+}
+
+// CheckContext is like Check, but accepts a context for cancellation and deadlines.
+// Most checks are purely local, but ctx is used by those that make network
+// calls, such as CheckOptions.RequireHandleResolves, and is passed to
+// CheckOptions.Logger with each record.
+func CheckContext(ctx context.Context, sess *atproto.ServerCreateSession_Output, opts ...Option) error {
+	cfg := newConfig(opts)
+	cfg.ctx = ctx
+	_, err := inspect(sess, cfg)
+	return err
+}
+
+// CheckWithClock is like Check, but evaluates the session's expiration
+// against now rather than the current wall clock time.
+// This is primarily useful for deterministic testing around expiry boundaries.
+// It is equivalent to Check(sess, WithNow(now)).
+func CheckWithClock(sess *atproto.ServerCreateSession_Output, now time.Time) error {
+	return Check(sess, WithNow(now))
+}
+
+// CheckWithLeeway is like Check, but widens the validity window of the
+// tokens by leeway to tolerate clock skew between the client and the server.
+// A zero leeway is identical to Check.
+// It is equivalent to Check(sess, WithLeeway(leeway)).
+func CheckWithLeeway(sess *atproto.ServerCreateSession_Output, leeway time.Duration) error {
+	return Check(sess, WithLeeway(leeway))
+}
+
+// Inspect performs the same validation as Check, and also returns
+// the expiration times of the access and refresh tokens.
+// This allows callers to schedule a refresh without re-parsing the JWTs.
+func Inspect(sess *atproto.ServerCreateSession_Output, opts ...Option) (accessExp, refreshExp time.Time, err error) {
+	res, err := inspect(sess, newConfig(opts))
 	if err != nil {
-		return err
+		return time.Time{}, time.Time{}, err
 	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return fmt.Errorf("unexpected type for claims: %T", token.Claims)
+	return res.AccessExpiry, res.RefreshExpiry, nil
+}
+
+// CheckResult describes what was found while checking a session.
+// It can be marshaled to and from JSON with stable field names and
+// RFC 3339 times, for example to cache validation results across restarts.
+// It never contains the raw tokens.
+type CheckResult struct {
+	// Scope is the scope claim of the access token.
+	Scope string `json:"scope"`
+
+	// AccessExpiry and RefreshExpiry are the expiration times
+	// of the access and refresh tokens.
+	AccessExpiry  time.Time `json:"accessExpiry"`
+	RefreshExpiry time.Time `json:"refreshExpiry"`
+
+	// IsAppPass reports whether the access token was created with an app password.
+	IsAppPass bool `json:"isAppPass"`
+
+	// IsMasterCredentials reports whether the access token was created with
+	// a master password. It is only set if CheckOptions.AllowMasterCredentials
+	// is set, since the check otherwise fails with ErrMasterCredentials.
+	IsMasterCredentials bool `json:"isMasterCredentials,omitempty"`
+
+	// Err is the validation error for the session, if any. It is only set by
+	// functions that report results for many sessions, such as CheckTokenPairs,
+	// and is not marshaled to JSON.
+	Err error `json:"-"`
+}
+
+// CheckDetailed performs the same validation as Check, and also returns
+// a CheckResult describing the session.
+// If validation fails, the CheckResult contains whatever was
+// learned about the session before the failure.
+func CheckDetailed(sess *atproto.ServerCreateSession_Output, opts ...Option) (CheckResult, error) {
+	return inspect(sess, newConfig(opts))
+}
+
+// CheckDetailedWith is like CheckDetailed, but performs the validation configured by opts.
+func CheckDetailedWith(sess *atproto.ServerCreateSession_Output, opts CheckOptions) (CheckResult, error) {
+	return inspect(sess, checkConfig{now: time.Now(), CheckOptions: opts})
+}
+
+// CheckWithScopes is like Check, but accepts any of the allowed scopes
+// as an app password scope. If no scopes are provided, the app password
+// scopes accepted by AppPassScopePolicy are accepted, which matches Check.
+// It is equivalent to Check(sess, WithScopes(allowed...)).
+func CheckWithScopes(sess *atproto.ServerCreateSession_Output, allowed ...string) error {
+	return Check(sess, WithScopes(allowed...))
+}
+
+// CheckTokens performs the same validation as Check, but accepts
+// the access and refresh JWTs directly rather than a full session.
+// This is useful for tools that persist only the tokens.
+func CheckTokens(accessJwt, refreshJwt string, opts ...Option) error {
+	cfg := newConfig(opts)
+	_, err := cfg.observe(nil, func() (CheckResult, error) { return inspectTokens(accessJwt, refreshJwt, cfg) })
+	return err
+}
+
+// SessionProvider is implemented by types that hold a session's tokens,
+// such as a caller's own session representation, so that they can be
+// validated with CheckProvider without converting to ServerCreateSession_Output.
+type SessionProvider interface {
+	AccessToken() string
+	RefreshToken() string
+}
+
+// CheckProvider is like CheckTokens, but validates the tokens returned by p.
+// A nil p results in ErrNilSession.
+func CheckProvider(p SessionProvider, opts ...Option) error {
+	cfg := newConfig(opts)
+	_, err := cfg.observe(nil, func() (CheckResult, error) {
+		if p == nil {
+			return CheckResult{}, ErrNilSession
+		}
+		return inspectTokens(p.AccessToken(), p.RefreshToken(), cfg)
+	})
+	return err
+}
+
+// CheckJSON unmarshals data, the raw JSON response body of
+// com.atproto.server.createSession, and then validates the session with Check.
+// Response fields that ServerCreateSession_Output does not yet include,
+// such as emailConfirmed, are also made available to the validation.
+func CheckJSON(data []byte, opts ...Option) error {
+	var sess atproto.ServerCreateSession_Output
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return fmt.Errorf("decoding session: %w", err)
+	}
+	var extra sessionExtras
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return fmt.Errorf("decoding session: %w", err)
+	}
+	cfg := newConfig(opts)
+	cfg.extra = extra
+	_, err := inspect(&sess, cfg)
+	return err
+}
+
+// CheckJSONWith is like CheckJSON, but performs the validation configured by opts.
+func CheckJSONWith(data []byte, opts CheckOptions) error {
+	return CheckJSON(data, WithOptions(opts))
+}
+
+// ValidateSessionShape checks that data, the raw JSON response body of
+// com.atproto.server.createSession, is a JSON object with the required
+// accessJwt, refreshJwt, did, and handle fields as strings, and that the
+// optional email field, if present, is a string or null. This catches
+// malformed responses, such as from an untrusted proxy, with an error naming
+// the offending field rather than a generic unmarshaling failure.
+// The tokens themselves are not validated; see CheckJSON.
+func ValidateSessionShape(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedSession, err)
+	}
+	if fields == nil {
+		return fmt.Errorf("%w: not a JSON object", ErrMalformedSession)
+	}
+	for _, name := range []string{"accessJwt", "refreshJwt", "did", "handle"} {
+		raw, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("%w: missing %s field", ErrMalformedSession, name)
+		}
+		var v string
+		if string(raw) == "null" || json.Unmarshal(raw, &v) != nil {
+			return fmt.Errorf("%w: %s field is not a string", ErrMalformedSession, name)
+		}
+	}
+	if raw, ok := fields["email"]; ok {
+		var v *string
+		if json.Unmarshal(raw, &v) != nil {
+			return fmt.Errorf("%w: email field is not a string", ErrMalformedSession)
+		}
 	}
-	if claims["scope"] != "com.atproto.appPass" {
-		return fmt.Errorf("%w: %w", ErrLoginUnauthorized, ErrMasterCredentials)
+	return nil
+}
+
+// sessionExtras holds createSession response fields that are not
+// part of the version of ServerCreateSession_Output used by this package.
+type sessionExtras struct {
+	EmailConfirmed *bool  `json:"emailConfirmed"`
+	Active         *bool  `json:"active"`
+	Status         string `json:"status"`
+}
+
+func inspect(sess *atproto.ServerCreateSession_Output, cfg checkConfig) (CheckResult, error) {
+	return cfg.observe(sess, func() (CheckResult, error) { return inspectSession(sess, cfg) })
+}
+
+// observe runs check and reports its outcome to the Observer, Logger, and
+// AuditSink of cfg. Every entry point that accepts options validates through
+// observe, so that the hooks see each validation. sess is the session being
+// validated, if any, and is used only for the audit event.
+func (cfg checkConfig) observe(sess *atproto.ServerCreateSession_Output, check func() (CheckResult, error)) (res CheckResult, err error) {
+	if cfg.Observer != nil {
+		defer func() { cfg.Observer(Classify(err)) }()
+	}
+	if cfg.Logger != nil {
+		defer func() { cfg.logResult(res, err) }()
 	}
+	if cfg.AuditSink != nil {
+		defer func() { cfg.recordAudit(sess, err) }()
+	}
+	if cfg.problems != nil {
+		// Runs before the deferred calls above, so that they see every problem.
+		defer func() { err = errors.Join(append(*cfg.problems, err)...) }()
+	}
+	return check()
+}
 
-	// Retrieve the expirations for the current and refresh JWT tokens
-	current, err := token.Claims.GetExpirationTime()
+func inspectSession(sess *atproto.ServerCreateSession_Output, cfg checkConfig) (res CheckResult, err error) {
+	if sess == nil {
+		return CheckResult{}, ErrNilSession
+	}
+	switch {
+	case sess.AccessJwt == "":
+		return CheckResult{}, fmt.Errorf("%w: %w", ErrIncompleteSession, ErrMissingAccessToken)
+	case sess.RefreshJwt == "":
+		return CheckResult{}, fmt.Errorf("%w: %w", ErrIncompleteSession, ErrMissingRefreshToken)
+	case sess.Did == "":
+		return CheckResult{}, fmt.Errorf("%w: missing did", ErrIncompleteSession)
+	}
+	if res, err = inspectTokens(sess.AccessJwt, sess.RefreshJwt, cfg); err != nil {
+		return res, err
+	}
+	if cfg.RequireValidDID {
+		if err := ValidateDID(sess.Did); err != nil {
+			if err := cfg.report(fmt.Errorf("session did: %w", err)); err != nil {
+				return res, err
+			}
+		}
+	}
+	if cfg.ExpectedHandle != "" {
+		if err := cfg.report(cfg.checkHandle(sess)); err != nil {
+			return res, err
+		}
+	}
+	if cfg.RequireEmailConfirmed && (cfg.extra.EmailConfirmed == nil || !*cfg.extra.EmailConfirmed) {
+		if err := cfg.report(ErrEmailNotConfirmed); err != nil {
+			return res, err
+		}
+	}
+	if cfg.RejectInactiveAccounts && (cfg.extra.Active != nil && !*cfg.extra.Active || cfg.extra.Status != "") {
+		if err := cfg.report(fmt.Errorf("%w: status %q", ErrAccountInactive, cfg.extra.Status)); err != nil {
+			return res, err
+		}
+	}
+	if cfg.RequireHandleResolves {
+		if err := cfg.report(cfg.checkHandleResolves(sess)); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+func inspectTokens(accessJwt, refreshJwt string, cfg checkConfig) (CheckResult, error) {
+	var res CheckResult
+	if accessJwt == "" {
+		return res, fmt.Errorf("%w: empty access token", ErrMissingAccessToken)
+	}
+	if refreshJwt == "" {
+		return res, fmt.Errorf("%w: empty refresh token", ErrMissingRefreshToken)
+	}
+	// Identical tokens still have the access token validated, but since the
+	// refresh token is then known to be wrong, it is not validated itself.
+	identical := accessJwt == refreshJwt
+	if identical {
+		if err := cfg.report(ErrIdenticalTokens); err != nil {
+			return res, err
+		}
+	}
+
+	claims, header, err := parseAccess(accessJwt, cfg)
 	if err != nil {
-		return err
+		return res, cfg.report(err)
 	}
-	if time.Until(current.Time) < 0 {
-		return fmt.Errorf("%w: refresh token was valid until %v", ErrSessionExpired, current.Time)
+	if res, err = cfg.checkAccess(claims, header); err != nil {
+		var expired *SessionExpiredError
+		if errors.As(err, &expired) && !identical {
+			// Best effort, so that callers can tell whether a refresh is possible.
+			if refreshClaims, err := cfg.parseClaims(refreshJwt); err == nil {
+				if exp, err := cfg.expiration(refreshClaims); err == nil {
+					res.RefreshExpiry, expired.RefreshExpiry = exp, exp
+				}
+			}
+		}
+		return res, err
+	}
+	if identical {
+		return res, nil
+	}
+	refreshClaims, err := cfg.parseClaims(refreshJwt)
+	if err != nil {
+		return res, cfg.report(fmt.Errorf("parsing refresh token: %w", err))
 	}
+	return cfg.checkRefresh(res, claims, refreshClaims)
+}
 
-	if token, _, err = jwt.NewParser().ParseUnverified(sess.RefreshJwt, jwt.MapClaims{}); err != nil {
+// CheckClaims performs the same scope and expiry checks as Check, but on
+// access and refresh token claims that the caller has already decoded,
+// evaluating the expiries against now. Check parses the tokens and then
+// performs the same checks.
+func CheckClaims(accessClaims, refreshClaims jwt.MapClaims, now time.Time) error {
+	if accessClaims == nil {
+		return fmt.Errorf("%w: nil access token claims", ErrMissingAccessToken)
+	}
+	if refreshClaims == nil {
+		return fmt.Errorf("%w: nil refresh token claims", ErrMissingRefreshToken)
+	}
+	cfg := checkConfig{now: now}
+	claims, err := cfg.accessClaims(accessClaims)
+	if err != nil {
 		return err
 	}
-
-	// TODO: this is 'refresh'. From initial look, original in karalabe/go-bluesky was checking for error,
-	// but was not immediately checking validity of the time itself.
-	_, err = token.Claims.GetExpirationTime()
+	res, err := cfg.checkAccess(claims, nil)
 	if err != nil {
 		return err
 	}
+	_, err = cfg.checkRefresh(res, claims, refreshClaims)
+	return err
+}
 
+// checkAccess validates the claims and header of an access token.
+// The header is nil if only the claims are known.
+func (cfg checkConfig) checkAccess(claims Claims, header map[string]interface{}) (CheckResult, error) {
+	var res CheckResult
+	if cfg.Strict {
+		if err := cfg.checkStrictAccess(claims, header); err != nil {
+			return res, err
+		}
+	}
+	if cfg.ExpectedAudience != "" {
+		err := checkAudienceDIDs(claims.Audience)
+		if err == nil && !contains(claims.Audience, cfg.ExpectedAudience) {
+			err = fmt.Errorf("%w: expected %q, got %q", ErrWrongAudience, cfg.ExpectedAudience, claims.Audience)
+		}
+		if err := cfg.report(err); err != nil {
+			return res, err
+		}
+	}
+	if cfg.ExpectedIssuer != "" && claims.Issuer != cfg.ExpectedIssuer {
+		if err := cfg.report(fmt.Errorf("%w: expected %q, got %q", ErrWrongIssuer, cfg.ExpectedIssuer, claims.Issuer)); err != nil {
+			return res, err
+		}
+	}
+	if len(cfg.AllowedIssuers) > 0 && !issuerAllowed(claims.Issuer, cfg.AllowedIssuers) {
+		if err := cfg.report(fmt.Errorf("%w: %q is not an allowed issuer", ErrWrongIssuer, claims.Issuer)); err != nil {
+			return res, err
+		}
+	}
+	if len(cfg.AllowedDIDs) > 0 && !contains(cfg.AllowedDIDs, claims.Subject) {
+		if err := cfg.report(fmt.Errorf("%w: %q", ErrDIDNotAllowed, claims.Subject)); err != nil {
+			return res, err
+		}
+	}
+	res.Scope = claims.Scope
+	var scopeErr error
+	if cfg.RejectInactiveAccounts && res.Scope == takendownScope {
+		scopeErr = fmt.Errorf("%w: access token has scope %q", ErrAccountInactive, res.Scope)
+	} else {
+		scopeErr = cfg.checkAccessScope(&res)
+	}
+	if err := cfg.report(scopeErr); err != nil {
+		return res, err
+	}
+
+	// Retrieve the expiration for the current JWT token
+	if claims.ExpiresAt.IsZero() {
+		return res, cfg.report(ErrMissingExpiration)
+	}
+	current := claims.ExpiresAt
+	res.AccessExpiry = current
+	if (cfg.MaxLifetime > 0 || cfg.MaxAge > 0) && claims.IssuedAt.IsZero() {
+		if err := cfg.report(ErrMissingIssuedAt); err != nil {
+			return res, err
+		}
+	} else {
+		if lifetime := current.Sub(claims.IssuedAt); cfg.MaxLifetime > 0 && lifetime > cfg.MaxLifetime {
+			if err := cfg.report(fmt.Errorf("%w: %v exceeds %v", ErrLifetimeTooLong, lifetime, cfg.MaxLifetime)); err != nil {
+				return res, err
+			}
+		}
+		if age := cfg.now.Sub(claims.IssuedAt); cfg.MaxAge > 0 && age > cfg.MaxAge {
+			if err := cfg.report(fmt.Errorf("%w: issued %v ago, exceeding %v", ErrSessionTooOld, age, cfg.MaxAge)); err != nil {
+				return res, err
+			}
+		}
+	}
+	if !cfg.IgnoreExpiry && cfg.expired(current) {
+		if err := cfg.report(&SessionExpiredError{Token: "access", AccessExpiry: current}); err != nil {
+			return res, err
+		}
+	} else if !cfg.IgnoreExpiry && cfg.MinRemaining > 0 && current.Before(cfg.now.Add(cfg.MinRemaining)) {
+		if err := cfg.report(fmt.Errorf("%w: access token expires at %v, less than %v from now", ErrExpiresBeforeDeadline, current, cfg.MinRemaining)); err != nil {
+			return res, err
+		}
+	}
+	if cfg.notYetValid(claims.NotBefore) {
+		if err := cfg.report(fmt.Errorf("%w: access token not valid before %v", ErrTokenNotYetValid, claims.NotBefore)); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// checkAccessScope validates res.Scope, the scope claim of an access token,
+// and records whether it is an app password or master password scope in res.
+func (cfg checkConfig) checkAccessScope(res *CheckResult) error {
+	var scopeErr error
+	switch {
+	case res.Scope != "":
+		scopeErr = cfg.checkScope(res.Scope)
+	case cfg.TreatMissingScopeAs == MissingScopeAsAppPass:
+		// Accepted as an app password.
+	case cfg.TreatMissingScopeAs == MissingScopeAsMaster:
+		scopeErr = ErrMasterCredentials
+	default:
+		return fmt.Errorf("%w: %w", ErrLoginUnauthorized, ErrMissingScope)
+	}
+	switch {
+	case scopeErr == nil:
+		res.IsAppPass = true
+	case errors.Is(scopeErr, ErrMasterCredentials) && cfg.AllowMasterCredentials:
+		res.IsMasterCredentials = true
+	case errors.Is(scopeErr, ErrMasterCredentials):
+		return &MasterCredentialsError{Scope: res.Scope, err: scopeErr}
+	default:
+		return fmt.Errorf("%w: %w", ErrLoginUnauthorized, scopeErr)
+	}
 	return nil
 }
+
+// checkRefresh validates the claims of a refresh token, and that they are
+// consistent with the claims of the access token that res describes.
+func (cfg checkConfig) checkRefresh(res CheckResult, access Claims, refreshClaims jwt.MapClaims) (CheckResult, error) {
+	if err := cfg.checkRevoked(refreshClaims); err != nil {
+		if err := cfg.report(fmt.Errorf("refresh token: %w", err)); err != nil {
+			return res, err
+		}
+	}
+	refresh, err := cfg.checkRefreshClaims(refreshClaims)
+	res.RefreshExpiry = refresh
+	if err != nil {
+		var expired *SessionExpiredError
+		if errors.As(err, &expired) {
+			expired.AccessExpiry = res.AccessExpiry
+		}
+		return res, err
+	}
+	if cfg.Strict && !refresh.IsZero() && refresh.Before(res.AccessExpiry) {
+		if err := cfg.report(fmt.Errorf("%w: refresh token expires at %v, access token at %v", ErrRefreshShorterThanAccess, refresh, res.AccessExpiry)); err != nil {
+			return res, err
+		}
+	}
+	refreshSub, err := refreshClaims.GetSubject()
+	if err != nil {
+		return res, cfg.report(fmt.Errorf("parsing refresh token: %w: %w", ErrMalformedToken, err))
+	}
+	if cfg.RequireValidDID {
+		if err := ValidateDID(access.Subject); err != nil {
+			if err := cfg.report(fmt.Errorf("access token sub: %w", err)); err != nil {
+				return res, err
+			}
+		}
+	}
+	if access.Subject != refreshSub {
+		if err := cfg.report(fmt.Errorf("%w: access token has %q, refresh token has %q", ErrTokenSubjectMismatch, access.Subject, refreshSub)); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// isOAuthToken reports whether claims look like those of an atproto
+// OAuth access token. Such tokens carry a client_id claim, are typically bound
+// to a DPoP key via a cnf claim, and have a space-separated scope that
+// includes "atproto".
+func isOAuthToken(claims jwt.MapClaims) bool {
+	if _, ok := claims["client_id"]; ok {
+		return true
+	}
+	if _, ok := claims["cnf"]; ok {
+		return true
+	}
+	scope, _ := claims["scope"].(string)
+	for _, s := range strings.Fields(scope) {
+		if s == "atproto" {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckRefreshOnly validates the scope and expiry of a refresh token
+// without requiring a valid access token. This supports starting from a
+// persisted refresh token after the access token has long expired.
+func CheckRefreshOnly(refreshJwt string, opts ...Option) error {
+	cfg := newConfig(opts)
+	_, err := cfg.observe(nil, func() (CheckResult, error) {
+		_, exp, err := checkRefreshToken(refreshJwt, cfg)
+		return CheckResult{RefreshExpiry: exp}, err
+	})
+	return err
+}
+
+// CheckScopeOnly validates only that the session's access token has an app
+// password scope, as Check does, skipping all expiry and other time based
+// checks. This is useful when expiry is handled separately, such as by
+// reacting to 401 responses from the PDS.
+func CheckScopeOnly(sess *atproto.ServerCreateSession_Output, opts ...Option) error {
+	cfg := newConfig(opts)
+	_, err := cfg.observe(sess, func() (CheckResult, error) {
+		if sess == nil {
+			return CheckResult{}, ErrNilSession
+		}
+		claims, _, err := parseAccess(sess.AccessJwt, cfg)
+		if err != nil {
+			return CheckResult{}, err
+		}
+		res := CheckResult{Scope: claims.Scope}
+		err = cfg.checkAccessScope(&res)
+		return res, err
+	})
+	return err
+}
+
+// checkRefreshToken parses refreshJwt and validates its scope and expiry.
+// It returns the parsed claims and the expiration time, which is set
+// even if the token has expired.
+func checkRefreshToken(refreshJwt string, cfg checkConfig) (jwt.MapClaims, time.Time, error) {
+	if refreshJwt == "" {
+		return nil, time.Time{}, fmt.Errorf("%w: empty refresh token", ErrMissingRefreshToken)
+	}
+	claims, err := cfg.parseClaims(refreshJwt)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("parsing refresh token: %w", err)
+	}
+	refresh, err := cfg.checkRefreshClaims(claims)
+	if err != nil {
+		return nil, refresh, err
+	}
+	return claims, refresh, nil
+}
+
+// checkRefreshClaims validates the scope and expiry of the claims of a
+// refresh token. It returns the expiration time, which is set
+// even if the token has expired.
+func (cfg checkConfig) checkRefreshClaims(claims jwt.MapClaims) (time.Time, error) {
+	if scope := claims["scope"]; !cfg.refreshScopeAllowed(scope) {
+		if err := cfg.report(fmt.Errorf("%w: %v", ErrUnexpectedRefreshScope, scope)); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	// The original in karalabe/go-bluesky was checking for an error here,
+	// but was not checking the validity of the refresh token's time itself.
+	refresh, err := cfg.expiration(claims)
+	if err != nil {
+		return time.Time{}, cfg.report(fmt.Errorf("parsing refresh token: %w", err))
+	}
+	if !cfg.IgnoreExpiry && cfg.expired(refresh) {
+		if err := cfg.report(&SessionExpiredError{Token: "refresh", RefreshExpiry: refresh}); err != nil {
+			return refresh, err
+		}
+	}
+	nbf, err := claims.GetNotBefore()
+	if err != nil {
+		return refresh, cfg.report(fmt.Errorf("parsing refresh token: %w: %w", ErrMalformedToken, err))
+	}
+	if nbf != nil && cfg.notYetValid(nbf.Time) {
+		if err := cfg.report(fmt.Errorf("%w: refresh token not valid before %v", ErrTokenNotYetValid, nbf.Time)); err != nil {
+			return refresh, err
+		}
+	}
+	return refresh, nil
+}
+
+// ScopeOf returns the raw scope claim of the provided access token.
+// An empty string is returned if the token has no scope claim.
+func ScopeOf(accessJwt string) (string, error) {
+	claims, err := parseClaims(accessJwt)
+	if err != nil {
+		return "", err
+	}
+	raw, ok := claims["scope"]
+	if !ok {
+		return "", nil
+	}
+	scope, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: unexpected type for scope claim: %T", ErrMalformedToken, raw)
+	}
+	return scope, nil
+}
+
+// GrantedScopes returns the scopes granted to the session's access token.
+// A compound scope claim holds several space-separated scopes, which are
+// returned individually, while the legacy "com.atproto.appPass" scope
+// is returned as a single-element slice. The result is empty if the
+// token has no scope claim.
+func GrantedScopes(sess *atproto.ServerCreateSession_Output) ([]string, error) {
+	if sess == nil {
+		return nil, ErrNilSession
+	}
+	scope, err := ScopeOf(sess.AccessJwt)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(scope), nil
+}
+
+// IsAppPassword reports whether the session's access token was created
+// with an app password, including a privileged app password. Unlike Check,
+// a master password session is reported as false rather than as an error.
+// The error is reserved for tokens that cannot be parsed.
+func IsAppPassword(sess *atproto.ServerCreateSession_Output) (bool, error) {
+	if sess == nil {
+		return false, ErrNilSession
+	}
+	scope, err := ScopeOf(sess.AccessJwt)
+	if err != nil {
+		return false, err
+	}
+	return isAppPassScope(scope), nil
+}
+
+// isAppPassScope reports whether scope is the scope of an access token
+// created with an app password, privileged or not.
+func isAppPassScope(scope string) bool {
+	return scope == appPassScope || scope == appPassPrivilegedScope
+}
+
+// IsPrivileged reports whether the session's access token was created with
+// a privileged app password, which grants access to direct messages.
+// If the scope claim does not distinguish this, such as for a master password
+// session, the error matches ErrPrivilegeUnknown.
+//
+// Check accepts privileged app password sessions by default. Callers that
+// reject them can list only "com.atproto.appPass" in CheckOptions.AllowedScopes.
+func IsPrivileged(sess *atproto.ServerCreateSession_Output) (bool, error) {
+	if sess == nil {
+		return false, ErrNilSession
+	}
+	scope, err := ScopeOf(sess.AccessJwt)
+	if err != nil {
+		return false, err
+	}
+	switch scope {
+	case appPassPrivilegedScope:
+		return true, nil
+	case appPassScope:
+		return false, nil
+	default:
+		return false, fmt.Errorf("%w: scope %q", ErrPrivilegeUnknown, scope)
+	}
+}
+
+// NeedsReauth reports whether sess would pass Check except that it was
+// created with a master password, meaning the user should be asked to log in
+// again with an app password. Any other problem, such as an expired or
+// malformed session, is returned as an error instead. The validation can be
+// adjusted with opts, except that master credentials are always allowed.
+func NeedsReauth(sess *atproto.ServerCreateSession_Output, opts ...Option) (bool, error) {
+	cfg := newConfig(opts)
+	cfg.AllowMasterCredentials = true
+	res, err := inspect(sess, cfg)
+	if err != nil {
+		return false, err
+	}
+	return res.IsMasterCredentials, nil
+}
+
+// DID returns the DID of the account that owns the session,
+// after confirming it matches the sub claim of the session's access token.
+func DID(sess *atproto.ServerCreateSession_Output) (string, error) {
+	if sess == nil {
+		return "", ErrNilSession
+	}
+	claims, err := parseClaims(sess.AccessJwt)
+	if err != nil {
+		return "", err
+	}
+	sub, err := claims.GetSubject()
+	if err != nil {
+		return "", err
+	}
+	if sess.Did != sub {
+		return "", fmt.Errorf("%w: session has %q, token has %q", ErrDIDMismatch, sess.Did, sub)
+	}
+	return sess.Did, nil
+}
+
+// Identity returns the handle and DID of the account that owns the session,
+// after confirming the session passes Check and that its DID matches
+// the sub claim of its access token.
+func Identity(sess *atproto.ServerCreateSession_Output) (handle, did string, err error) {
+	if err := Check(sess); err != nil {
+		return "", "", err
+	}
+	if did, err = DID(sess); err != nil {
+		return "", "", err
+	}
+	return sess.Handle, did, nil
+}
+
+// SameAccount reports whether sessions a and b belong to the same account,
+// by comparing the sub claims of their access tokens. This is useful after
+// a refresh to confirm the new session is for the original account.
+func SameAccount(a, b *atproto.ServerCreateSession_Output) (bool, error) {
+	if a == nil || b == nil {
+		return false, ErrNilSession
+	}
+	ca, err := ParseAccess(a.AccessJwt)
+	if err != nil {
+		return false, err
+	}
+	cb, err := ParseAccess(b.AccessJwt)
+	if err != nil {
+		return false, err
+	}
+	if ca.Subject == "" || cb.Subject == "" {
+		return false, fmt.Errorf("%w: missing sub claim", ErrMalformedToken)
+	}
+	return ca.Subject == cb.Subject, nil
+}
+
+// ScopeChanged reports whether the access tokens of sessions prev and next
+// have different scope claims, and returns both scopes. This confirms that
+// logging in again with an app password replaced a master password session.
+func ScopeChanged(prev, next *atproto.ServerCreateSession_Output) (changed bool, oldScope, newScope string, err error) {
+	if prev == nil || next == nil {
+		return false, "", "", ErrNilSession
+	}
+	if oldScope, err = ScopeOf(prev.AccessJwt); err != nil {
+		return false, "", "", err
+	}
+	if newScope, err = ScopeOf(next.AccessJwt); err != nil {
+		return false, "", "", err
+	}
+	return oldScope != newScope, oldScope, newScope, nil
+}
+
+// AuthHeader validates sess with Check and returns the value of an
+// Authorization header for authenticating XRPC calls with the session.
+// If the session has expired, the error matches ErrSessionExpired,
+// indicating the session should be refreshed first.
+func AuthHeader(sess *atproto.ServerCreateSession_Output) (string, error) {
+	if err := Check(sess); err != nil {
+		return "", err
+	}
+	return "Bearer " + sess.AccessJwt, nil
+}
@@ -0,0 +1,202 @@
+package appkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/thepudds/bluesky-aux/appkey/appkeytest"
+)
+
+const testDID = "did:plc:abcdefghijklmnopqrstuvwx"
+
+func TestPrivilegedAppPassword(t *testing.T) {
+	sess := appkeytest.NewSession(testDID, "alice.test", appPassPrivilegedScope, time.Now().Add(time.Hour))
+	if err := Check(sess); err != nil {
+		t.Errorf("Check: %v", err)
+	}
+	if ok, err := IsAppPassword(sess); !ok || err != nil {
+		t.Errorf("IsAppPassword = %v, %v, want true, nil", ok, err)
+	}
+	if ok, err := IsPrivileged(sess); !ok || err != nil {
+		t.Errorf("IsPrivileged = %v, %v, want true, nil", ok, err)
+	}
+	if reauth, err := NeedsReauth(sess); reauth || err != nil {
+		t.Errorf("NeedsReauth = %v, %v, want false, nil", reauth, err)
+	}
+	if d, err := Diagnose(sess); err != nil || !strings.Contains(d, "(privileged app password)") {
+		t.Errorf("Diagnose = %q, %v", d, err)
+	}
+	err := Check(sess, WithScopes(appPassScope))
+	if !errors.Is(err, ErrMasterCredentials) {
+		t.Errorf("Check restricted to %q = %v, want ErrMasterCredentials", appPassScope, err)
+	}
+}
+
+func TestMasterCredentialsErrorIs(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	master := appkeytest.NewSession(testDID, "alice.test", appkeytest.MasterScope, exp)
+	noScope := appkeytest.NewSession(testDID, "alice.test", "", exp)
+	policyErr := errors.New("policy rejected scope")
+
+	tests := []struct {
+		name             string
+		err              error
+		wantUnauthorized bool
+		wantMaster       bool
+	}{
+		{"Check master", Check(master), true, true},
+		{"zero MasterCredentialsError", &MasterCredentialsError{}, true, true},
+		{"wrapped MasterCredentialsError", fmt.Errorf("login: %w", &MasterCredentialsError{Scope: appkeytest.MasterScope}), true, true},
+		{"policy wrapping ErrMasterCredentials", CheckWithScopePolicy(master, func(string) error {
+			return fmt.Errorf("%w: %w", ErrMasterCredentials, policyErr)
+		}), true, true},
+		{"policy rejection", CheckWithScopePolicy(master, func(string) error { return policyErr }), true, false},
+		{"missing scope", Check(noScope), true, false},
+	}
+	for _, tt := range tests {
+		if got := errors.Is(tt.err, ErrLoginUnauthorized); got != tt.wantUnauthorized {
+			t.Errorf("%s: errors.Is(%v, ErrLoginUnauthorized) = %v, want %v", tt.name, tt.err, got, tt.wantUnauthorized)
+		}
+		if got := errors.Is(tt.err, ErrMasterCredentials); got != tt.wantMaster {
+			t.Errorf("%s: errors.Is(%v, ErrMasterCredentials) = %v, want %v", tt.name, tt.err, got, tt.wantMaster)
+		}
+	}
+
+	var mce *MasterCredentialsError
+	if err := Check(master); !errors.As(err, &mce) || mce.Scope != appkeytest.MasterScope {
+		t.Errorf("Check = %v, want a MasterCredentialsError with scope %q", err, appkeytest.MasterScope)
+	}
+	if err := CheckWithScopePolicy(master, func(string) error { return fmt.Errorf("%w: %w", ErrMasterCredentials, policyErr) }); !errors.Is(err, policyErr) {
+		t.Errorf("CheckWithScopePolicy = %v, want it to wrap the policy error", err)
+	}
+}
+
+func TestUnexpectedRefreshScope(t *testing.T) {
+	now := time.Now()
+	for _, scope := range []string{appkeytest.MasterScope, appkeytest.AppPassScope, "com.atproto.refresh.other", ""} {
+		for _, refreshExp := range []time.Time{now.Add(24 * time.Hour), now.Add(-time.Hour)} {
+			refresh := appkeytest.Refresh(testDID, refreshExp)
+			refresh.Scope = scope
+			sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, now.Add(time.Hour))
+			sess.RefreshJwt = refresh.Encode()
+
+			for name, err := range map[string]error{
+				"Check":            Check(sess),
+				"CheckRefreshOnly": CheckRefreshOnly(sess.RefreshJwt),
+			} {
+				if !errors.Is(err, ErrUnexpectedRefreshScope) {
+					t.Errorf("%s with refresh scope %q expiring %v = %v, want ErrUnexpectedRefreshScope", name, scope, refreshExp, err)
+				}
+				if errors.Is(err, ErrSessionExpired) || errors.Is(err, ErrMasterCredentials) {
+					t.Errorf("%s with refresh scope %q expiring %v = %v, want neither expired nor master credentials", name, scope, refreshExp, err)
+				}
+			}
+		}
+	}
+}
+
+type resolverFunc func(ctx context.Context, handle string) (string, error)
+
+func (f resolverFunc) ResolveHandle(ctx context.Context, handle string) (string, error) {
+	return f(ctx, handle)
+}
+
+func TestCheckContextPassesContext(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "value")
+	sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, time.Now().Add(time.Hour))
+	var got context.Context
+	resolver := resolverFunc(func(ctx context.Context, handle string) (string, error) {
+		got = ctx
+		return testDID, nil
+	})
+	err := CheckContext(ctx, sess, WithOptions(CheckOptions{RequireHandleResolves: true, HandleResolver: resolver}))
+	if err != nil {
+		t.Fatalf("CheckContext: %v", err)
+	}
+	if got == nil || got.Value(key{}) != "value" {
+		t.Errorf("HandleResolver did not receive the context passed to CheckContext")
+	}
+}
+
+func TestCheckExpiryBoundaries(t *testing.T) {
+	exp := time.Unix(1700000000, 0)
+	refreshExp := exp.Add(-appkeytest.AccessLifetime + appkeytest.RefreshLifetime)
+	sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp)
+	// lapsed has a refresh token that expires an hour before its access token.
+	lapsed := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp)
+	lapsed.RefreshJwt = appkeytest.Token{
+		Scope:     appkeytest.RefreshScope,
+		Subject:   testDID,
+		Audience:  appkeytest.PDSDID,
+		Issuer:    appkeytest.PDSDID,
+		IssuedAt:  exp.Add(-appkeytest.AccessLifetime),
+		ExpiresAt: exp.Add(-time.Hour),
+	}.Encode()
+
+	tests := []struct {
+		name string
+		sess *atproto.ServerCreateSession_Output
+		now  time.Time
+		opts []Option
+		want *SessionExpiredError // nil if the session is valid
+	}{
+		{"1ns before exp", sess, exp.Add(-time.Nanosecond), nil, nil},
+		{"at exp", sess, exp, nil, nil},
+		{"1ns after exp", sess, exp.Add(time.Nanosecond), nil,
+			&SessionExpiredError{Token: "access", AccessExpiry: exp, RefreshExpiry: refreshExp}},
+		{"within leeway", sess, exp.Add(time.Minute), []Option{WithLeeway(time.Minute)}, nil},
+		{"past leeway", sess, exp.Add(time.Minute + time.Nanosecond), []Option{WithLeeway(time.Minute)},
+			&SessionExpiredError{Token: "access", AccessExpiry: exp, RefreshExpiry: refreshExp}},
+		{"refresh 1ns before exp", lapsed, exp.Add(-time.Hour - time.Nanosecond), nil, nil},
+		{"refresh 1ns after exp", lapsed, exp.Add(-time.Hour + time.Nanosecond), nil,
+			&SessionExpiredError{Token: "refresh", AccessExpiry: exp, RefreshExpiry: exp.Add(-time.Hour)}},
+		{"refresh within leeway", lapsed, exp.Add(-time.Hour + time.Minute), []Option{WithLeeway(time.Minute)}, nil},
+		// The access token is reported, with the refresh expiry showing that
+		// the session cannot be refreshed either.
+		{"both expired", sess, refreshExp.Add(time.Nanosecond), nil,
+			&SessionExpiredError{Token: "access", AccessExpiry: exp, RefreshExpiry: refreshExp}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(tt.sess, append([]Option{WithNow(tt.now)}, tt.opts...)...)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Check = %v, want nil", err)
+				}
+				return
+			}
+			var got *SessionExpiredError
+			if !errors.As(err, &got) {
+				t.Fatalf("Check = %v, want *SessionExpiredError", err)
+			}
+			if got.Token != tt.want.Token || !got.AccessExpiry.Equal(tt.want.AccessExpiry) || !got.RefreshExpiry.Equal(tt.want.RefreshExpiry) {
+				t.Errorf("Check = %+v, want %+v", got, tt.want)
+			}
+			if len(tt.opts) == 0 {
+				if err2 := CheckWithClock(tt.sess, tt.now); err2 == nil || err2.Error() != err.Error() {
+					t.Errorf("CheckWithClock = %v, want %v", err2, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckWithLeeway(t *testing.T) {
+	exp := time.Now().Add(-time.Minute).Truncate(time.Second)
+	sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp)
+	if err := CheckWithLeeway(sess, 0); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("CheckWithLeeway(0) = %v, want ErrSessionExpired", err)
+	}
+	if err := CheckWithLeeway(sess, 30*time.Second); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("CheckWithLeeway(30s) = %v, want ErrSessionExpired", err)
+	}
+	if err := CheckWithLeeway(sess, time.Hour); err != nil {
+		t.Errorf("CheckWithLeeway(1h) = %v, want nil", err)
+	}
+}
@@ -0,0 +1,33 @@
+package appkey
+
+import (
+	"context"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// didKey is the context key under which CheckAndContext stores the DID.
+type didKey struct{}
+
+// CheckAndContext validates sess with CheckContext and, on success, returns
+// a child of ctx that carries the session's DID, which can be retrieved with
+// DIDFromContext. The DID must match the sub claim of the access token,
+// as with DID. This supports middleware that validates a session and then
+// passes the authenticated identity to request handlers.
+func CheckAndContext(ctx context.Context, sess *atproto.ServerCreateSession_Output, opts ...Option) (context.Context, error) {
+	if err := CheckContext(ctx, sess, opts...); err != nil {
+		return ctx, err
+	}
+	did, err := DID(sess)
+	if err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, didKey{}, did), nil
+}
+
+// DIDFromContext returns the DID stored in ctx by CheckAndContext,
+// and whether one was present.
+func DIDFromContext(ctx context.Context) (string, bool) {
+	did, ok := ctx.Value(didKey{}).(string)
+	return did, ok
+}
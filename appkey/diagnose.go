@@ -0,0 +1,70 @@
+package appkey
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// Diagnose returns a multi-line report describing everything known about
+// sess: its DID and handle, the scope of its access token, the expiry of
+// both tokens and the time remaining, and any problem reported by Check.
+// The tokens are redacted as by SafeString, so the report is safe to paste
+// into a bug report. Problems with the session are described in the report
+// rather than returned; the error is reserved for a nil session.
+func Diagnose(sess *atproto.ServerCreateSession_Output) (string, error) {
+	if sess == nil {
+		return "", ErrNilSession
+	}
+	now := time.Now()
+	var b strings.Builder
+	line := func(label, format string, args ...interface{}) {
+		fmt.Fprintf(&b, "%-15s %s\n", label+":", fmt.Sprintf(format, args...))
+	}
+	line("did", "%s", sess.Did)
+	line("handle", "%s", sess.Handle)
+	line("access token", "%s", redact(sess.AccessJwt))
+	line("refresh token", "%s", redact(sess.RefreshJwt))
+
+	if access, err := ParseClaims(sess.AccessJwt); err != nil {
+		line("access claims", "unparseable: %v", err)
+	} else {
+		kind := "master password"
+		switch {
+		case access.Scope == "":
+			kind = "missing scope"
+		case access.Scope == appPassScope:
+			kind = "app password"
+		case access.Scope == appPassPrivilegedScope:
+			kind = "privileged app password"
+		}
+		line("scope", "%s (%s)", access.Scope, kind)
+		line("access expiry", "%s", describeExpiry(access.ExpiresAt, now))
+	}
+	if refresh, err := ParseClaims(sess.RefreshJwt); err != nil {
+		line("refresh claims", "unparseable: %v", err)
+	} else {
+		line("refresh expiry", "%s", describeExpiry(refresh.ExpiresAt, now))
+	}
+
+	if _, err := inspect(sess, checkConfig{now: now}); err != nil {
+		line("problem", "%v", err)
+	} else {
+		line("problem", "none")
+	}
+	return b.String(), nil
+}
+
+// describeExpiry describes exp and how it relates to now.
+func describeExpiry(exp, now time.Time) string {
+	switch {
+	case exp.IsZero():
+		return "none"
+	case exp.Before(now):
+		return fmt.Sprintf("%s (expired %v ago)", formatTime(exp), now.Sub(exp).Round(time.Second))
+	default:
+		return fmt.Sprintf("%s (in %v)", formatTime(exp), exp.Sub(now).Round(time.Second))
+	}
+}
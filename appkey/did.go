@@ -0,0 +1,52 @@
+package appkey
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrMalformedDID is returned if a DID is not a valid did:plc or did:web.
+var ErrMalformedDID = errors.New("malformed did")
+
+// ValidateDID checks that did is a syntactically valid did:plc or did:web,
+// the two DID methods supported by atproto. A did:plc identifier must be
+// 24 characters of lowercase base32, and a did:web identifier must be a
+// hostname, optionally followed by a percent-encoded port.
+func ValidateDID(did string) error {
+	method, id, ok := strings.Cut(strings.TrimPrefix(did, "did:"), ":")
+	if !ok || !strings.HasPrefix(did, "did:") {
+		return fmt.Errorf("%w: %q does not have the form did:<method>:<id>", ErrMalformedDID, did)
+	}
+	switch method {
+	case "plc":
+		if len(id) != 24 {
+			return fmt.Errorf("%w: %q has an invalid identifier length", ErrMalformedDID, did)
+		}
+		for i := 0; i < len(id); i++ {
+			c := id[i]
+			if !('a' <= c && c <= 'z' || '2' <= c && c <= '7') {
+				return fmt.Errorf("%w: %q has an invalid character", ErrMalformedDID, did)
+			}
+		}
+	case "web":
+		host, port, hasPort := strings.Cut(id, "%3A")
+		if host == "" || hasPort && port == "" {
+			return fmt.Errorf("%w: %q has an empty host or port", ErrMalformedDID, did)
+		}
+		for i := 0; i < len(host); i++ {
+			c := host[i]
+			if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.') {
+				return fmt.Errorf("%w: %q has an invalid character", ErrMalformedDID, did)
+			}
+		}
+		for i := 0; i < len(port); i++ {
+			if c := port[i]; c < '0' || c > '9' {
+				return fmt.Errorf("%w: %q has an invalid port", ErrMalformedDID, did)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unsupported method %q", ErrMalformedDID, method)
+	}
+	return nil
+}
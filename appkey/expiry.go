@@ -0,0 +1,468 @@
+package appkey
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrExpiresBeforeDeadline is returned by CheckUntil if the session's
+// access token expires before the given deadline, and if the access token
+// has less than CheckOptions.MinRemaining of its lifetime left.
+var ErrExpiresBeforeDeadline = errors.New("session expires before deadline")
+
+// ErrMissingIssuedAt is returned if an access token has no iat claim
+// where one is required. It also matches ErrMissingClaim.
+var ErrMissingIssuedAt = fmt.Errorf("%w: iat", ErrMissingClaim)
+
+// CheckUntil is like Check, but additionally requires the access token to
+// remain valid until deadline, returning ErrExpiresBeforeDeadline otherwise.
+// This lets a job with a known runtime fail fast at startup rather than
+// partway through when the token lapses. The validation can be adjusted
+// with opts, as for Check.
+func CheckUntil(sess *atproto.ServerCreateSession_Output, deadline time.Time, opts ...Option) error {
+	res, err := CheckDetailed(sess, opts...)
+	if err != nil {
+		return err
+	}
+	if res.AccessExpiry.Before(deadline) {
+		return fmt.Errorf("%w: access token expires at %v, deadline is %v", ErrExpiresBeforeDeadline, res.AccessExpiry, deadline)
+	}
+	return nil
+}
+
+// TimeUntilExpiry returns the duration from now until the session's
+// access token expires. The duration is negative if the token has already expired.
+// No scope validation is performed.
+func TimeUntilExpiry(sess *atproto.ServerCreateSession_Output) (time.Duration, error) {
+	exp, err := accessExpiry(sess)
+	if err != nil {
+		return 0, err
+	}
+	return time.Until(exp), nil
+}
+
+// ShouldRefresh reports whether the session's access token expires
+// within the given window, including if it has already expired.
+func ShouldRefresh(sess *atproto.ServerCreateSession_Output, within time.Duration) (bool, error) {
+	d, err := TimeUntilExpiry(sess)
+	if err != nil {
+		return false, err
+	}
+	return d <= within, nil
+}
+
+// CoversDuration reports whether the session's access token remains valid
+// for at least d from now, such as before starting an operation expected to
+// take d. Like ShouldRefresh, only the expiry of the access token is examined.
+func CoversDuration(sess *atproto.ServerCreateSession_Output, d time.Duration) (bool, error) {
+	exp, err := accessExpiry(sess)
+	if err != nil {
+		return false, err
+	}
+	return time.Now().Add(d).Before(exp), nil
+}
+
+// RefreshExpiresWithin reports whether the session's refresh token expires
+// within d, including if it has already expired. Once the refresh token
+// expires the session cannot be refreshed, so this can be used to warn
+// users to log in again before that happens.
+func RefreshExpiresWithin(sess *atproto.ServerCreateSession_Output, d time.Duration) (bool, error) {
+	if sess == nil {
+		return false, ErrNilSession
+	}
+	exp, err := expiryOf(sess.RefreshJwt)
+	if err != nil {
+		return false, err
+	}
+	return time.Until(exp) <= d, nil
+}
+
+// RefreshExpiry returns the exp claim of refreshJwt, for clients that
+// persist only the refresh token, such as to prune stored sessions whose
+// refresh token has lapsed. The token is parsed and its scope validated as
+// by Check, but an expired token is not an error: its expiration time is
+// returned so that the caller can compare it with the current time.
+func RefreshExpiry(refreshJwt string) (time.Time, error) {
+	_, exp, err := checkRefreshToken(refreshJwt, checkConfig{now: time.Now()})
+	var expired *SessionExpiredError
+	if err != nil && !errors.As(err, &expired) {
+		return time.Time{}, err
+	}
+	return exp, nil
+}
+
+// EffectiveExpiry returns the time at which the session becomes unusable,
+// which is the earlier of the access and refresh token expiration times.
+func EffectiveExpiry(sess *atproto.ServerCreateSession_Output) (time.Time, error) {
+	access, err := accessExpiry(sess)
+	if err != nil {
+		return time.Time{}, err
+	}
+	refresh, err := expiryOf(sess.RefreshJwt)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if refresh.Before(access) {
+		return refresh, nil
+	}
+	return access, nil
+}
+
+// RefreshDeadline returns the absolute time at which the session should be
+// refreshed, which is the access token's expiry minus before.
+// This complements ShouldRefresh for schedulers that use absolute times.
+func RefreshDeadline(sess *atproto.ServerCreateSession_Output, before time.Duration) (time.Time, error) {
+	exp, err := accessExpiry(sess)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return exp.Add(-before), nil
+}
+
+// RefreshJitter returns a random time within window before the session's
+// access token expires at which to refresh it. Spreading refreshes out this
+// way avoids many clients refreshing at the same moment. If rng is nil,
+// the default source of the math/rand package is used.
+func RefreshJitter(sess *atproto.ServerCreateSession_Output, window time.Duration, rng *rand.Rand) (time.Time, error) {
+	exp, err := accessExpiry(sess)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if window <= 0 {
+		return exp, nil
+	}
+	var n int64
+	if rng != nil {
+		n = rng.Int63n(int64(window))
+	} else {
+		n = rand.Int63n(int64(window))
+	}
+	return exp.Add(-time.Duration(n)), nil
+}
+
+// IsExpired reports whether the session's access token has expired.
+// Only the exp claim of the access token is examined, without the scope
+// validation performed by Check, which makes it suitable for hot paths.
+func IsExpired(sess *atproto.ServerCreateSession_Output) (bool, error) {
+	exp, err := accessExpiry(sess)
+	if err != nil {
+		return false, err
+	}
+	return exp.Before(time.Now()), nil
+}
+
+// ExpiryUnix returns the exp claim of tokenString as Unix seconds,
+// which is convenient for storing expiries as integers.
+func ExpiryUnix(tokenString string) (int64, error) {
+	exp, err := expiryOf(tokenString)
+	if err != nil {
+		return 0, err
+	}
+	return exp.Unix(), nil
+}
+
+// IssuedAt returns the iat claim of the session's access token, which is
+// when the session was created or last refreshed. If the token has no iat
+// claim, the error matches ErrMissingIssuedAt.
+func IssuedAt(sess *atproto.ServerCreateSession_Output) (time.Time, error) {
+	if sess == nil {
+		return time.Time{}, ErrNilSession
+	}
+	c, err := ParseClaims(sess.AccessJwt)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if c.IssuedAt.IsZero() {
+		return time.Time{}, ErrMissingIssuedAt
+	}
+	return c.IssuedAt, nil
+}
+
+// CheckWithDriftReport validates sess as by Check, but against trustedNow,
+// the time from a trusted source such as an NTP server, rather than the local
+// clock. It also returns the drift of the session's issuer: the iat claim of
+// the access token minus trustedNow. For a freshly created or refreshed
+// session, a drift far from zero means the PDS clock is skewed, and comparing
+// trustedNow with time.Now helps diagnose spurious ErrSessionExpired errors
+// caused by a skewed local clock. The drift is returned even if validation
+// fails, provided the iat claim is present; otherwise the error matches
+// ErrMissingIssuedAt. Any WithNow in opts is overridden by trustedNow.
+func CheckWithDriftReport(sess *atproto.ServerCreateSession_Output, trustedNow time.Time, opts ...Option) (drift time.Duration, err error) {
+	iat, err := IssuedAt(sess)
+	if err != nil {
+		return 0, err
+	}
+	opts = append(opts[:len(opts):len(opts)], WithNow(trustedNow))
+	return iat.Sub(trustedNow), Check(sess, opts...)
+}
+
+// ValidityRemaining returns the fraction of the access token's lifetime
+// that remains, from 1.0 when just issued to 0.0 once expired, computed from
+// its iat and exp claims. A token without an iat claim results in ErrMissingIssuedAt.
+func ValidityRemaining(sess *atproto.ServerCreateSession_Output) (float64, error) {
+	iat, exp, err := accessLifetime(sess)
+	if err != nil {
+		return 0, err
+	}
+	remaining := float64(time.Until(exp)) / float64(exp.Sub(iat))
+	return math.Max(0, math.Min(1, remaining)), nil
+}
+
+// ShouldRefreshPercent reports whether more than fraction of the access
+// token's lifetime, from its iat claim to its exp claim, has elapsed.
+// For example, a fraction of 0.8 refreshes once 80% of the lifetime has elapsed.
+// The fraction must be between 0 and 1. A token without an iat claim results
+// in ErrMissingIssuedAt, in which case callers can fall back to ShouldRefresh.
+func ShouldRefreshPercent(sess *atproto.ServerCreateSession_Output, fraction float64) (bool, error) {
+	if !(fraction >= 0 && fraction <= 1) {
+		return false, fmt.Errorf("appkey: refresh fraction %v is not between 0 and 1", fraction)
+	}
+	remaining, err := ValidityRemaining(sess)
+	if err != nil {
+		return false, err
+	}
+	return 1-remaining > fraction, nil
+}
+
+// accessLifetime returns the iat and exp claims of the access token of sess,
+// confirming both are present and that exp is after iat.
+func accessLifetime(sess *atproto.ServerCreateSession_Output) (iat, exp time.Time, err error) {
+	if sess == nil {
+		return time.Time{}, time.Time{}, ErrNilSession
+	}
+	c, err := ParseClaims(sess.AccessJwt)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	switch {
+	case c.IssuedAt.IsZero():
+		return time.Time{}, time.Time{}, ErrMissingIssuedAt
+	case c.ExpiresAt.IsZero():
+		return time.Time{}, time.Time{}, ErrMissingExpiration
+	case !c.ExpiresAt.After(c.IssuedAt):
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: exp is not after iat", ErrMalformedToken)
+	}
+	return c.IssuedAt, c.ExpiresAt, nil
+}
+
+// FastExpiry returns the exp claim of tokenString without fully parsing it.
+// The header is checked as ExpiryUnix would, but the payload is only validated
+// as JSON and scanned for a top-level integer exp claim rather than
+// unmarshaled, which makes FastExpiry suitable for per-request middleware.
+// If the payload is at all unusual, such as containing escaped strings,
+// a duplicate, non-integer or zero-padded exp claim, or a truncated object,
+// FastExpiry falls back to the same parsing as ExpiryUnix, so both always
+// return the same result.
+// Like ExpiryUnix, FastExpiry does not verify the signature.
+func FastExpiry(tokenString string) (time.Time, error) {
+	if exp, ok := fastExpiry(tokenString); ok {
+		return time.Unix(exp, 0), nil
+	}
+	return expiryOf(tokenString)
+}
+
+// fastExpiry implements the fast path of FastExpiry, reporting false
+// if the fallback parser is needed.
+func fastExpiry(tokenString string) (int64, bool) {
+	// The token must be accepted by the fallback parser too, so the header
+	// is decoded as it would be, and the payload must be valid JSON.
+	parts := strings.Split(tokenString, ".")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	parser := newParser()
+	if !fastHeaderValid(parser, parts[0]) {
+		return 0, false
+	}
+	payload, err := parser.DecodeSegment(parts[1])
+	if err != nil || bytes.IndexByte(payload, '\\') >= 0 || !json.Valid(payload) {
+		return 0, false
+	}
+
+	// Without escapes, every string ends at the next quote,
+	// so it is enough to track the nesting depth and skip over strings.
+	// The payload must be a single object whose closing brace is found,
+	// so that a truncated payload is left to the fallback parser.
+	if start := skipSpace(payload, 0); start == len(payload) || payload[start] != '{' {
+		return 0, false
+	}
+	var exp int64
+	var found, closed bool
+	depth := 0
+	for i := 0; i < len(payload); i++ {
+		switch payload[i] {
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth < 0 {
+				return 0, false
+			}
+			if depth == 0 {
+				if payload[i] != '}' || skipSpace(payload, i+1) != len(payload) {
+					return 0, false
+				}
+				closed = true
+			}
+		case '"':
+			n := bytes.IndexByte(payload[i+1:], '"')
+			if n < 0 {
+				return 0, false
+			}
+			key := string(payload[i+1 : i+1+n])
+			i += n + 1
+			if depth != 1 || key != "exp" {
+				continue
+			}
+			j := skipSpace(payload, i+1)
+			if j == len(payload) || payload[j] != ':' {
+				continue // a string value rather than a key
+			}
+			j = skipSpace(payload, j+1)
+			end := j
+			for end < len(payload) && '0' <= payload[end] && payload[end] <= '9' {
+				end++
+			}
+			// Larger integers would be rounded by the fallback parser's float64.
+			if found || end == j || end-j > 15 || payload[j] == '0' || end < len(payload) && !strings.ContainsRune(" \t\r\n,}", rune(payload[end])) {
+				return 0, false
+			}
+			exp, err = strconv.ParseInt(string(payload[j:end]), 10, 64)
+			if err != nil || exp == 0 {
+				return 0, false
+			}
+			found = true
+			i = end - 1
+		}
+	}
+	if !found || !closed {
+		return 0, false
+	}
+	return exp, true
+}
+
+// lastFastHeader is the most recent header segment accepted by
+// fastHeaderValid. Tokens from one PDS usually share a header, so
+// remembering one is enough to avoid decoding it again.
+var lastFastHeader atomic.Pointer[string]
+
+// fastHeaderValid reports whether the header segment seg is decoded by
+// parser, as by the fallback parser, to an object naming a known algorithm.
+func fastHeaderValid(parser *jwt.Parser, seg string) bool {
+	if last := lastFastHeader.Load(); last != nil && *last == seg {
+		return true
+	}
+	data, err := parser.DecodeSegment(seg)
+	if err != nil {
+		return false
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return false
+	}
+	if alg, ok := header["alg"].(string); !ok || jwt.GetSigningMethod(alg) == nil {
+		return false
+	}
+	lastFastHeader.Store(&seg)
+	return true
+}
+
+// skipSpace returns the index of the first non-whitespace byte in b at or after i.
+func skipSpace(b []byte, i int) int {
+	for i < len(b) && (b[i] == ' ' || b[i] == '\t' || b[i] == '\r' || b[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// accessExpiry returns the exp claim of the access token of sess.
+func accessExpiry(sess *atproto.ServerCreateSession_Output) (time.Time, error) {
+	if sess == nil {
+		return time.Time{}, ErrNilSession
+	}
+	return expiryOf(sess.AccessJwt)
+}
+
+// expiryOf returns the exp claim of tokenString.
+func expiryOf(tokenString string) (time.Time, error) {
+	claims, err := parseClaims(tokenString)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return expiration(claims)
+}
+
+// expiration returns the exp claim from claims.
+// GetExpirationTime returns a nil date without an error if the
+// claim is absent, which is reported here as ErrMissingExpiration.
+//
+// The exp claim may be encoded as a JSON integer or as a float with a
+// fractional part. The jwt package truncates fractional dates to its global
+// TimePrecision (one second by default), so the full precision is
+// restored here from the raw claim, whichever parser decoded it.
+func expiration(claims jwt.MapClaims) (time.Time, error) {
+	exp, err := claims.GetExpirationTime()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %w", ErrMalformedToken, err)
+	}
+	if exp == nil {
+		return time.Time{}, ErrMissingExpiration
+	}
+	if t, ok := fractionalDate(claims["exp"]); ok {
+		return t, nil
+	}
+	return exp.Time, nil
+}
+
+// fractionalDate returns the time of a numeric date claim with a fractional
+// part, as decoded either by the default parser as a float64 or by a parser
+// using jwt.WithJSONNumber as a json.Number. For a json.Number, the fraction
+// is taken from its decimal digits, so no precision is lost to floating point.
+// It reports false if raw is not a fractional numeric date.
+func fractionalDate(raw interface{}) (time.Time, bool) {
+	switch v := raw.(type) {
+	case float64:
+		sec, frac := math.Modf(v)
+		return time.Unix(int64(sec), int64(frac*1e9)), true
+	case json.Number:
+		s := string(v)
+		if strings.ContainsAny(s, "eE") {
+			f, err := v.Float64()
+			if err != nil {
+				return time.Time{}, false
+			}
+			return fractionalDate(f)
+		}
+		whole, frac, ok := strings.Cut(s, ".")
+		if !ok {
+			return time.Time{}, false
+		}
+		sec, err := strconv.ParseInt(whole, 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		nsec, err := strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+		if err != nil || nsec < 0 {
+			return time.Time{}, false
+		}
+		if strings.HasPrefix(whole, "-") {
+			nsec = -nsec
+		}
+		return time.Unix(sec, nsec), true
+	}
+	return time.Time{}, false
+}
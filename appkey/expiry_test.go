@@ -0,0 +1,181 @@
+package appkey
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/thepudds/bluesky-aux/appkey/appkeytest"
+)
+
+func TestFastExpiry(t *testing.T) {
+	tests := []struct {
+		payload string
+		exp     int64
+		fast    bool
+	}{
+		{`{"exp":1700000000}`, 1700000000, true},
+		{` {"sub":"did:plc:x", "exp" : 1700000000 } `, 1700000000, true},
+		{`{"nested":{"exp":1},"exp":1700000000}`, 1700000000, true},
+		{`{"exp":123`, 0, false},
+		{`{"exp":123,"sub":"x"`, 0, false},
+		{`{"exp":123}}`, 0, false},
+		{`{"exp":123}{`, 0, false},
+		{`{"exp":0123}`, 0, false},
+		{`{"exp":1700000000.5}`, 0, false},
+		{`{"exp":"1700000000"}`, 0, false},
+		{`{"exp":1,"exp":2}`, 0, false},
+		{`[{"exp":1700000000}]`, 0, false},
+		{`{"sub":"a\"b","exp":1700000000}`, 0, false},
+		{`{"exp":1700000000,"sub":}`, 0, false},
+		{`{"exp":1700000000,"sub":"x",}`, 0, false},
+		{`{"exp":1234567890123456}`, 0, false},
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	for _, tt := range tests {
+		tok := header + "." + base64.RawURLEncoding.EncodeToString([]byte(tt.payload)) + ".c2ln"
+		exp, ok := fastExpiry(tok)
+		if ok != tt.fast || exp != tt.exp {
+			t.Errorf("fastExpiry(%s) = %d, %v; want %d, %v", tt.payload, exp, ok, tt.exp, tt.fast)
+		}
+	}
+
+	// The header and the number of segments are checked as by ExpiryUnix.
+	tok := appkeytest.AppPass(testDID, time.Unix(1700000000, 0)).Encode()
+	_, rest, _ := strings.Cut(tok, ".")
+	for _, bad := range []string{
+		"garbage." + rest,
+		base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT"}`)) + "." + rest,
+		base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256K"}`)) + "." + rest,
+		tok + ".extra",
+		strings.TrimSuffix(rest, "."),
+	} {
+		if exp, ok := fastExpiry(bad); ok {
+			t.Errorf("fastExpiry(%q) = %d, want fallback", bad, exp)
+		}
+		if _, err := FastExpiry(bad); !errors.Is(err, ErrMalformedToken) {
+			t.Errorf("FastExpiry(%q) = %v, want ErrMalformedToken", bad, err)
+		}
+	}
+}
+
+func FuzzFastExpiry(f *testing.F) {
+	tok := appkeytest.AppPass(testDID, time.Unix(1700000000, 0)).Encode()
+	_, rest, _ := strings.Cut(tok, ".")
+	f.Add(tok)
+	f.Add(padSegments(tok))
+	f.Add("garbage." + rest)
+	f.Add(tok + ".extra")
+	f.Add(appkeytest.Token{Claims: map[string]any{"exp": 1700000000, "sub": "x", "nested": map[string]any{"exp": 1}}}.Encode())
+	f.Fuzz(func(t *testing.T, tok string) {
+		fast, err := FastExpiry(tok)
+		exp, fullErr := ExpiryUnix(tok)
+		if (err == nil) != (fullErr == nil) {
+			t.Fatalf("FastExpiry(%q) = %v, %v; ExpiryUnix = %d, %v", tok, fast, err, exp, fullErr)
+		}
+		if err == nil && fast.Unix() != exp {
+			t.Fatalf("FastExpiry(%q) = %d; ExpiryUnix = %d", tok, fast.Unix(), exp)
+		}
+	})
+}
+
+func BenchmarkFastExpiry(b *testing.B) {
+	tok := appkeytest.AppPass(testDID, time.Now().Add(time.Hour)).Encode()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := FastExpiry(tok); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExpiryUnix(b *testing.B) {
+	tok := appkeytest.AppPass(testDID, time.Now().Add(time.Hour)).Encode()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ExpiryUnix(tok); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMissingExpiration(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	noExp := appkeytest.Token{Scope: appkeytest.AppPassScope, Subject: testDID}.Encode()
+	refreshNoExp := appkeytest.Refresh(testDID, time.Time{}).Encode()
+
+	sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp)
+	sess.AccessJwt = noExp
+	if err := Check(sess); !errors.Is(err, ErrMissingExpiration) {
+		t.Errorf("Check without access exp = %v, want ErrMissingExpiration", err)
+	}
+	sess = appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp)
+	sess.RefreshJwt = refreshNoExp
+	if err := Check(sess); !errors.Is(err, ErrMissingExpiration) {
+		t.Errorf("Check without refresh exp = %v, want ErrMissingExpiration", err)
+	}
+	if err := CheckRefreshOnly(refreshNoExp); !errors.Is(err, ErrMissingExpiration) {
+		t.Errorf("CheckRefreshOnly = %v, want ErrMissingExpiration", err)
+	}
+	if _, err := ExpiryUnix(noExp); !errors.Is(err, ErrMissingExpiration) {
+		t.Errorf("ExpiryUnix = %v, want ErrMissingExpiration", err)
+	}
+	if _, err := FastExpiry(noExp); !errors.Is(err, ErrMissingExpiration) {
+		t.Errorf("FastExpiry = %v, want ErrMissingExpiration", err)
+	}
+}
+
+func TestFractionalExpiration(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Truncate(time.Second).Add(900000095 * time.Nanosecond)
+	sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp)
+	access := appkeytest.AppPass(testDID, exp)
+	access.Claims = map[string]any{"exp": json.Number(fmt.Sprintf("%d.%09d", exp.Unix(), exp.Nanosecond()))}
+	sess.AccessJwt = access.Encode()
+
+	parsers := map[string][]jwt.ParserOption{
+		"default":    nil,
+		"JSONNumber": {jwt.WithJSONNumber()},
+	}
+	for name, parser := range parsers {
+		res, err := CheckDetailed(sess, WithOptions(CheckOptions{ParserOptions: parser}))
+		if err != nil {
+			t.Fatalf("%s parser: CheckDetailed: %v", name, err)
+		}
+		// A float64 cannot hold the full nanosecond precision of a Unix time.
+		if d := res.AccessExpiry.Sub(exp).Abs(); d > time.Microsecond {
+			t.Errorf("%s parser: AccessExpiry = %v, want %v", name, res.AccessExpiry, exp)
+		}
+		if parser != nil && !res.AccessExpiry.Equal(exp) {
+			t.Errorf("%s parser: AccessExpiry = %v, want exactly %v", name, res.AccessExpiry, exp)
+		}
+	}
+}
+
+func TestFractionalDate(t *testing.T) {
+	tests := []struct {
+		raw  interface{}
+		want time.Time
+		ok   bool
+	}{
+		{json.Number("1700000000.5"), time.Unix(1700000000, 5e8), true},
+		{json.Number("1700000000.900000095"), time.Unix(1700000000, 900000095), true},
+		{json.Number("1700000000.1234567891"), time.Unix(1700000000, 123456789), true},
+		{json.Number("1.7e9"), time.Unix(1700000000, 0), true},
+		{json.Number("-1.5"), time.Unix(-1, -5e8), true},
+		{json.Number("1700000000"), time.Time{}, false},
+		{json.Number("1700000000.-5"), time.Time{}, false},
+		{float64(1700000000.5), time.Unix(1700000000, 5e8), true},
+		{"1700000000.5", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := fractionalDate(tt.raw)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("fractionalDate(%#v) = %v, %v; want %v, %v", tt.raw, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package appkey
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// Fingerprint returns a stable key identifying sess, suitable for
+// deduplicating stored sessions or as a cache key. It is the hex-encoded
+// SHA-256 hash of the session's DID, the jti claim of its refresh token,
+// and the iat claim of its access token, so the same session always has the
+// same fingerprint, including across restarts, while the tokens themselves
+// cannot be recovered from it. Refreshing a session changes its fingerprint.
+//
+// If neither the jti nor the iat claim is present, the error matches
+// ErrMissingClaim, because the DID alone does not identify a session.
+func Fingerprint(sess *atproto.ServerCreateSession_Output) (string, error) {
+	did, err := DID(sess)
+	if err != nil {
+		return "", err
+	}
+	access, err := ParseClaims(sess.AccessJwt)
+	if err != nil {
+		return "", err
+	}
+	refresh, err := parseClaims(sess.RefreshJwt)
+	if err != nil {
+		return "", fmt.Errorf("parsing refresh token: %w", err)
+	}
+	jti, _ := refresh["jti"].(string)
+	if jti == "" && access.IssuedAt.IsZero() {
+		return "", fmt.Errorf("%w: refresh token jti or access token iat", ErrMissingClaim)
+	}
+	var iat string
+	if !access.IssuedAt.IsZero() {
+		iat = strconv.FormatInt(access.IssuedAt.UnixNano(), 10)
+	}
+	sum := sha256.Sum256([]byte(did + "\x00" + jti + "\x00" + iat))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Changed reports whether the fingerprint of sess differs from
+// previousFingerprint, as returned by an earlier call to Fingerprint or
+// Changed, and returns the new fingerprint so that the caller can store it.
+// An empty previousFingerprint, meaning none was recorded, is always
+// reported as changed. Like Fingerprint, Changed confirms that the session's
+// DID matches its access token, but does not otherwise validate it;
+// call Check as well before using a changed session.
+func Changed(sess *atproto.ServerCreateSession_Output, previousFingerprint string) (bool, string, error) {
+	fp, err := Fingerprint(sess)
+	if err != nil {
+		return false, "", err
+	}
+	return fp != previousFingerprint, fp, nil
+}
@@ -0,0 +1,114 @@
+package appkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// ErrMalformedHandle is returned if a handle does not have valid handle syntax.
+var ErrMalformedHandle = errors.New("malformed handle")
+
+// ErrHandleDIDMismatch is returned if CheckOptions.RequireHandleResolves is
+// set and the session's handle does not resolve to the session's DID.
+var ErrHandleDIDMismatch = errors.New("handle does not resolve to session did")
+
+// ErrWrongHandle is returned if CheckOptions.ExpectedHandle is set and
+// the session is for an account with a different handle.
+var ErrWrongHandle = errors.New("wrong handle")
+
+// HandleResolver resolves a handle to a DID, such as via DNS or the
+// /.well-known/atproto-did endpoint. This package does not implement
+// handle resolution; see CheckOptions.RequireHandleResolves.
+type HandleResolver interface {
+	ResolveHandle(ctx context.Context, handle string) (did string, err error)
+}
+
+// NormalizedHandle returns the session's handle trimmed of surrounding
+// whitespace and lowercased, after confirming it has valid handle syntax.
+// Handles are case-insensitive, so the normalized form is suitable
+// as a storage key.
+func NormalizedHandle(sess *atproto.ServerCreateSession_Output) (string, error) {
+	if sess == nil {
+		return "", ErrNilSession
+	}
+	handle := normalizeHandle(sess.Handle)
+	if err := validateHandle(handle); err != nil {
+		return "", err
+	}
+	return handle, nil
+}
+
+// normalizeHandle trims and lowercases handle.
+func normalizeHandle(handle string) string {
+	return strings.ToLower(strings.TrimSpace(handle))
+}
+
+// validateHandle checks that the normalized handle is domain-like:
+// at least two dot-separated labels of ASCII letters, digits, and hyphens,
+// where labels do not begin or end with a hyphen and the final label
+// does not begin with a digit.
+func validateHandle(handle string) error {
+	if len(handle) > 253 {
+		return fmt.Errorf("%w: too long", ErrMalformedHandle)
+	}
+	labels := strings.Split(handle, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("%w: %q is not domain-like", ErrMalformedHandle, handle)
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 {
+			return fmt.Errorf("%w: %q has an invalid label length", ErrMalformedHandle, handle)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("%w: %q has a label beginning or ending with '-'", ErrMalformedHandle, handle)
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-') {
+				return fmt.Errorf("%w: %q has an invalid character", ErrMalformedHandle, handle)
+			}
+		}
+	}
+	if tld := labels[len(labels)-1]; '0' <= tld[0] && tld[0] <= '9' {
+		return fmt.Errorf("%w: %q has a final label beginning with a digit", ErrMalformedHandle, handle)
+	}
+	return nil
+}
+
+// checkHandleResolves confirms that the handle of sess resolves to its DID
+// using cfg.HandleResolver.
+func (cfg checkConfig) checkHandleResolves(sess *atproto.ServerCreateSession_Output) error {
+	if cfg.HandleResolver == nil {
+		return errors.New("appkey: RequireHandleResolves is set without a HandleResolver")
+	}
+	did, err := DID(sess)
+	if err != nil {
+		return err
+	}
+	resolved, err := cfg.HandleResolver.ResolveHandle(cfg.context(), normalizeHandle(sess.Handle))
+	if err != nil {
+		return fmt.Errorf("resolving handle: %w", err)
+	}
+	if resolved != did {
+		return fmt.Errorf("%w: %q resolves to %q, session has %q", ErrHandleDIDMismatch, sess.Handle, resolved, did)
+	}
+	return nil
+}
+
+// checkHandle confirms that the handle of sess matches cfg.ExpectedHandle,
+// and that the session's DID matches its access token, so that the handle
+// is known to belong to the account the token was issued for.
+func (cfg checkConfig) checkHandle(sess *atproto.ServerCreateSession_Output) error {
+	did, err := DID(sess)
+	if err != nil {
+		return err
+	}
+	if normalizeHandle(sess.Handle) != normalizeHandle(cfg.ExpectedHandle) {
+		return fmt.Errorf("%w: expected %q, session for %q has %q", ErrWrongHandle, cfg.ExpectedHandle, did, sess.Handle)
+	}
+	return nil
+}
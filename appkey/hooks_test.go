@@ -0,0 +1,50 @@
+package appkey
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thepudds/bluesky-aux/appkey/appkeytest"
+)
+
+type recordingSink []ValidationEvent
+
+func (s *recordingSink) Record(event ValidationEvent) { *s = append(*s, event) }
+
+func TestHooksCalledByEveryEntryPoint(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.MasterScope, exp)
+
+	entryPoints := map[string]func(opts ...Option) error{
+		"Check":       func(opts ...Option) error { return Check(sess, opts...) },
+		"CheckTokens": func(opts ...Option) error { return CheckTokens(sess.AccessJwt, sess.RefreshJwt, opts...) },
+		"CheckProvider": func(opts ...Option) error {
+			return CheckProvider(tokenPair{sess.AccessJwt, sess.RefreshJwt}, opts...)
+		},
+		"CheckProvider(nil)": func(opts ...Option) error { return CheckProvider(nil, opts...) },
+		"CheckRefreshOnly":   func(opts ...Option) error { return CheckRefreshOnly(sess.AccessJwt, opts...) },
+		"CheckScopeOnly":     func(opts ...Option) error { return CheckScopeOnly(sess, opts...) },
+	}
+	for name, check := range entryPoints {
+		var observed []Category
+		var sink recordingSink
+		err := check(WithOptions(CheckOptions{
+			Observer:  func(c Category) { observed = append(observed, c) },
+			AuditSink: &sink,
+		}))
+		if err == nil {
+			t.Errorf("%s succeeded, want an error", name)
+		}
+		if len(observed) != 1 || observed[0] != Classify(err) {
+			t.Errorf("%s: Observer saw %v, want [%v]", name, observed, Classify(err))
+		}
+		if len(sink) != 1 || sink[0].Outcome != Classify(err) {
+			t.Errorf("%s: AuditSink saw %v, want one event with outcome %v", name, sink, Classify(err))
+		}
+	}
+}
+
+type tokenPair [2]string
+
+func (p tokenPair) AccessToken() string  { return p[0] }
+func (p tokenPair) RefreshToken() string { return p[1] }
@@ -0,0 +1,42 @@
+package appkey
+
+import (
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// Info summarizes the tokens of a session.
+// Like CheckResult, it can be marshaled to and from JSON.
+type Info struct {
+	AccessScope    string    `json:"accessScope"`
+	AccessIssuedAt time.Time `json:"accessIssuedAt"`
+	AccessExpiry   time.Time `json:"accessExpiry"`
+
+	RefreshScope  string    `json:"refreshScope"`
+	RefreshExpiry time.Time `json:"refreshExpiry"`
+}
+
+// SessionInfo parses both tokens of sess and returns an Info describing them.
+// Unlike Check, no validation of the scopes or expiries is performed, so an
+// expired or master password session is still described.
+func SessionInfo(sess *atproto.ServerCreateSession_Output) (Info, error) {
+	if sess == nil {
+		return Info{}, ErrNilSession
+	}
+	access, err := ParseClaims(sess.AccessJwt)
+	if err != nil {
+		return Info{}, err
+	}
+	refresh, err := ParseClaims(sess.RefreshJwt)
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{
+		AccessScope:    access.Scope,
+		AccessIssuedAt: access.IssuedAt,
+		AccessExpiry:   access.ExpiresAt,
+		RefreshScope:   refresh.Scope,
+		RefreshExpiry:  refresh.ExpiresAt,
+	}, nil
+}
@@ -0,0 +1,84 @@
+package appkey
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thepudds/bluesky-aux/appkey/appkeytest"
+)
+
+func TestCheckResultJSON(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	res, err := CheckDetailed(appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Err = errors.New("not marshaled")
+	data, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`"scope":`, `"accessExpiry":`, `"refreshExpiry":`, `"isAppPass":true`} {
+		if !strings.Contains(string(data), field) {
+			t.Errorf("json.Marshal(CheckResult) = %s, missing %s", data, field)
+		}
+	}
+	for _, field := range []string{"isMasterCredentials", "Err", "not marshaled"} {
+		if strings.Contains(string(data), field) {
+			t.Errorf("json.Marshal(CheckResult) = %s, unexpectedly contains %s", data, field)
+		}
+	}
+
+	var got CheckResult
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	res.Err = nil
+	if got.Scope != res.Scope || !got.AccessExpiry.Equal(res.AccessExpiry) ||
+		!got.RefreshExpiry.Equal(res.RefreshExpiry) || got.IsAppPass != res.IsAppPass ||
+		got.IsMasterCredentials != res.IsMasterCredentials || got.Err != nil {
+		t.Errorf("round trip of %+v = %+v", res, got)
+	}
+}
+
+func TestInfoJSON(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	info, err := SessionInfo(appkeytest.NewSession(testDID, "alice.test", appkeytest.MasterScope, exp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	want := []string{"accessExpiry", "accessIssuedAt", "accessScope", "refreshExpiry", "refreshScope"}
+	for _, name := range want {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("json.Marshal(Info) = %s, missing %q", data, name)
+		}
+	}
+	if len(names) != len(want) {
+		t.Errorf("json.Marshal(Info) has fields %q, want %q", names, want)
+	}
+
+	var got Info
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.AccessScope != info.AccessScope || got.RefreshScope != info.RefreshScope ||
+		!got.AccessIssuedAt.Equal(info.AccessIssuedAt) || !got.AccessExpiry.Equal(info.AccessExpiry) ||
+		!got.RefreshExpiry.Equal(info.RefreshExpiry) {
+		t.Errorf("round trip of %+v = %+v", info, got)
+	}
+}
@@ -0,0 +1,31 @@
+package appkey
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// Login creates a session on the PDS behind client by calling
+// com.atproto.server.createSession, and then validates the resulting
+// session with Check. If password is a master password rather than an
+// app password, the returned error matches ErrMasterCredentials, and if the
+// server rejects the credentials, it matches ErrLoginUnauthorized.
+// The client is not modified, so callers typically set client.Auth
+// from the returned session. In tests, client can be obtained from
+// appkeytest.PDS to serve canned responses without a live server.
+func Login(ctx context.Context, client *xrpc.Client, identifier, password string) (*atproto.ServerCreateSession_Output, error) {
+	sess, err := atproto.ServerCreateSession(ctx, client, &atproto.ServerCreateSession_Input{
+		Identifier: identifier,
+		Password:   password,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating session: %w", FromXRPCError(err))
+	}
+	if err := CheckContext(ctx, sess); err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
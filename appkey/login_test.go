@@ -0,0 +1,111 @@
+package appkey
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/thepudds/bluesky-aux/appkey/appkeytest"
+)
+
+func TestLogin(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	pds := &appkeytest.PDS{
+		CreateSession: func(identifier, password string) (*atproto.ServerCreateSession_Output, int) {
+			switch password {
+			case "app-pass":
+				return appkeytest.NewSession(testDID, identifier, appkeytest.AppPassScope, exp), http.StatusOK
+			case "master":
+				return appkeytest.NewSession(testDID, identifier, appkeytest.MasterScope, exp), http.StatusOK
+			case "busy":
+				return nil, http.StatusTooManyRequests
+			default:
+				return nil, http.StatusUnauthorized
+			}
+		},
+	}
+	tests := []struct {
+		password string
+		want     error
+	}{
+		{"app-pass", nil},
+		{"master", ErrMasterCredentials},
+		{"wrong", ErrLoginUnauthorized},
+	}
+	for _, tt := range tests {
+		_, err := Login(context.Background(), pds.Client(), "alice.test", tt.password)
+		if !errors.Is(err, tt.want) || (tt.want == nil) != (err == nil) {
+			t.Errorf("Login with %q = %v, want %v", tt.password, err, tt.want)
+		}
+	}
+
+	_, err := Login(context.Background(), pds.Client(), "alice.test", "busy")
+	if errors.Is(err, ErrLoginUnauthorized) || !IsRetryable(err) {
+		t.Errorf("Login rate limited = %v, want a retryable error", err)
+	}
+}
+
+func TestLoginWithBackoff(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	tests := []struct {
+		name     string
+		statuses []int // statuses of the first attempts; later attempts succeed
+		scope    string
+		want     error
+		attempts int
+	}{
+		{"success", nil, appkeytest.AppPassScope, nil, 1},
+		{"rate limited", []int{http.StatusTooManyRequests, http.StatusTooManyRequests}, appkeytest.AppPassScope, nil, 3},
+		{"server errors", []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable}, appkeytest.AppPassScope, nil, 4},
+		{"unauthorized", []int{http.StatusUnauthorized}, appkeytest.AppPassScope, ErrLoginUnauthorized, 1},
+		{"master password", nil, appkeytest.MasterScope, ErrMasterCredentials, 1},
+		{"bad request", []int{http.StatusBadRequest}, appkeytest.AppPassScope, nil, 1},
+		{"attempts exhausted", []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError}, appkeytest.AppPassScope, nil, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			pds := &appkeytest.PDS{
+				CreateSession: func(identifier, _ string) (*atproto.ServerCreateSession_Output, int) {
+					attempts++
+					if attempts <= len(tt.statuses) {
+						return nil, tt.statuses[attempts-1]
+					}
+					return appkeytest.NewSession(testDID, identifier, tt.scope, exp), http.StatusOK
+				},
+			}
+			opts := BackoffOptions{InitialDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+			sess, err := LoginWithBackoff(context.Background(), pds.Client(), "alice.test", "app-pass", opts)
+			if attempts != tt.attempts {
+				t.Errorf("made %d attempts, want %d", attempts, tt.attempts)
+			}
+			failed := tt.want != nil || tt.attempts <= len(tt.statuses)
+			if failed != (err != nil) || (tt.want != nil && !errors.Is(err, tt.want)) {
+				t.Errorf("LoginWithBackoff = %v, want %v", err, tt.want)
+			}
+			if (err == nil) != (sess != nil) {
+				t.Errorf("LoginWithBackoff = %v, %v; want a session exactly when there is no error", sess, err)
+			}
+		})
+	}
+}
+
+func TestLoginWithBackoffCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	attempts := 0
+	pds := &appkeytest.PDS{
+		CreateSession: func(string, string) (*atproto.ServerCreateSession_Output, int) {
+			attempts++
+			cancel()
+			return nil, http.StatusTooManyRequests
+		},
+	}
+	_, err := LoginWithBackoff(ctx, pds.Client(), "alice.test", "app-pass", BackoffOptions{InitialDelay: time.Hour})
+	if !errors.Is(err, context.Canceled) || attempts != 1 {
+		t.Errorf("LoginWithBackoff = %v after %d attempts, want context.Canceled after 1", err, attempts)
+	}
+}
@@ -0,0 +1,148 @@
+package appkey
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Category is a coarse classification of the outcome of a check,
+// suitable for metrics labels.
+type Category int
+
+const (
+	// CategoryOK means the session passed validation.
+	CategoryOK Category = iota
+
+	// CategoryMasterCredentials means a master password was used.
+	CategoryMasterCredentials
+
+	// CategoryExpired means the session has expired.
+	CategoryExpired
+
+	// CategoryMalformed means the session or its tokens are missing,
+	// malformed, or inconsistent with each other.
+	CategoryMalformed
+
+	// CategoryUnauthorized means the session was rejected for another
+	// reason, such as a missing scope claim.
+	CategoryUnauthorized
+
+	// CategoryUnknown means the error is not one defined by this package.
+	CategoryUnknown
+)
+
+var categoryNames = [...]string{
+	CategoryOK:                "ok",
+	CategoryMasterCredentials: "master_credentials",
+	CategoryExpired:           "expired",
+	CategoryMalformed:         "malformed",
+	CategoryUnauthorized:      "unauthorized",
+	CategoryUnknown:           "unknown",
+}
+
+// String returns a short snake_case name for c.
+func (c Category) String() string {
+	if c < 0 || int(c) >= len(categoryNames) {
+		return "unknown"
+	}
+	return categoryNames[c]
+}
+
+// MarshalText implements encoding.TextMarshaler, so that a Category is
+// encoded by its name, for example in a JSON-encoded ValidationEvent.
+func (c Category) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// Classify maps an error returned by this package to its Category, giving
+// callers a single switch over outcomes for metrics and alerting.
+// Errors not defined by this package, such as network errors,
+// are CategoryUnknown.
+func Classify(err error) Category {
+	switch {
+	case err == nil:
+		return CategoryOK
+	case errors.Is(err, ErrMasterCredentials):
+		// Checked before ErrLoginUnauthorized, which it also matches.
+		return CategoryMasterCredentials
+	case errors.Is(err, ErrSessionExpired),
+		errors.Is(err, ErrExpiresBeforeDeadline):
+		return CategoryExpired
+	case errors.Is(err, ErrMalformedToken),
+		errors.Is(err, ErrMissingAccessToken),
+		errors.Is(err, ErrMissingRefreshToken),
+		errors.Is(err, ErrMissingExpiration),
+		errors.Is(err, ErrMissingClaim),
+		errors.Is(err, ErrNilSession),
+		errors.Is(err, ErrIncompleteSession),
+		errors.Is(err, ErrMalformedSession),
+		errors.Is(err, ErrUnexpectedRefreshScope),
+		errors.Is(err, ErrTokenSubjectMismatch),
+		errors.Is(err, ErrDIDMismatch),
+		errors.Is(err, ErrMalformedDID),
+		errors.Is(err, ErrIdenticalTokens),
+		errors.Is(err, ErrRefreshShorterThanAccess):
+		return CategoryMalformed
+	case errors.Is(err, ErrLoginUnauthorized),
+		errors.Is(err, ErrUnsupportedTokenType),
+		errors.Is(err, ErrUnexpectedTokenType),
+		errors.Is(err, ErrWrongAudience),
+		errors.Is(err, ErrWrongIssuer),
+		errors.Is(err, ErrDIDNotAllowed),
+		errors.Is(err, ErrHandleDIDMismatch),
+		errors.Is(err, ErrWrongHandle),
+		errors.Is(err, ErrTokenRevoked),
+		errors.Is(err, ErrLifetimeTooLong),
+		errors.Is(err, ErrTokenTooOld),
+		errors.Is(err, ErrSessionTooOld),
+		errors.Is(err, ErrTokenNotYetValid),
+		errors.Is(err, ErrTokenFromFuture),
+		errors.Is(err, ErrEmailNotConfirmed),
+		errors.Is(err, ErrAccountInactive),
+		errors.Is(err, ErrUnsafeAlgorithm),
+		errors.Is(err, ErrUnexpectedAlgorithm),
+		errors.Is(err, ErrInvalidSignature):
+		return CategoryUnauthorized
+	default:
+		return CategoryUnknown
+	}
+}
+
+// Counters tallies the outcomes of checks by Category, for example with
+// its Observe method as CheckOptions.Observer, and renders them with WriteTo.
+// A Counters is safe for concurrent use. The zero value is ready to use.
+type Counters struct {
+	counts [len(categoryNames)]atomic.Int64
+}
+
+// Observe increments the count for cat.
+func (c *Counters) Observe(cat Category) {
+	if cat < 0 || int(cat) >= len(c.counts) {
+		cat = CategoryUnknown
+	}
+	c.counts[cat].Add(1)
+}
+
+// Count returns the number of observed outcomes in cat.
+func (c *Counters) Count(cat Category) int64 {
+	if cat < 0 || int(cat) >= len(c.counts) {
+		return 0
+	}
+	return c.counts[cat].Load()
+}
+
+// WriteTo writes a snapshot of the counts to w in the OpenMetrics text format,
+// as a counter named appkey_checks with an outcome label for each Category.
+func (c *Counters) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	b.WriteString("# TYPE appkey_checks counter\n")
+	b.WriteString("# HELP appkey_checks Session checks by outcome.\n")
+	for cat := range c.counts {
+		fmt.Fprintf(&b, "appkey_checks_total{outcome=%q} %d\n", Category(cat), c.counts[cat].Load())
+	}
+	b.WriteString("# EOF\n")
+	return b.WriteTo(w)
+}
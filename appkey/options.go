@@ -0,0 +1,659 @@
+package appkey
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// CheckOptions configures the validation performed by CheckWith.
+// The zero value performs the same validation as Check.
+type CheckOptions struct {
+	// Leeway widens the validity window of the tokens to tolerate
+	// clock skew between the client and the server.
+	Leeway time.Duration
+
+	// AllowedScopes lists the access token scopes accepted as app password scopes.
+	// If empty, the scopes accepted by AppPassScopePolicy are accepted.
+	// AllowedScopes is ignored if ScopePolicy is set.
+	AllowedScopes []string
+
+	// ScopePolicy, if non-nil, decides whether the scope of an access token
+	// is acceptable by returning nil, or an error explaining the rejection.
+	// A returned error is wrapped with ErrLoginUnauthorized.
+	// See AppPassScopePolicy for the default policy.
+	ScopePolicy func(scope string) error
+
+	// IgnoreExpiry skips the expiry checks, so an expired session does not
+	// produce ErrSessionExpired. The scope checks are still performed.
+	// This is useful for workflows that plan to refresh immediately.
+	IgnoreExpiry bool
+
+	// Observer, if non-nil, is called with the outcome of each check
+	// before it returns. This allows wiring in metrics without this package
+	// depending on a metrics library.
+	Observer func(Category)
+
+	// ParserOptions configure the jwt.Parser used for the tokens. They apply
+	// to decoding, such as jwt.WithJSONNumber or jwt.WithStrictDecoding, to
+	// the signing method, with jwt.WithValidMethods rejecting other methods
+	// with ErrUnexpectedAlgorithm, and to the jwt package's own validation of
+	// the claims, which runs in addition to the checks of this package whether
+	// or not VerifyWith is set. A jwt.WithAudience, jwt.WithIssuer, or
+	// jwt.WithSubject mismatch is reported as ErrWrongAudience, ErrWrongIssuer,
+	// or ErrDIDNotAllowed, and a future iat with jwt.WithIssuedAt as
+	// ErrTokenFromFuture. The windows of jwt.WithLeeway and Leeway combine:
+	// a token is only expired, or not yet valid, if it is outside both.
+	// The jwt validation uses the time set by WithNow unless the options
+	// include jwt.WithTimeFunc.
+	ParserOptions []jwt.ParserOption
+
+	// Strict enables the additional validation performed by StrictCheck.
+	Strict bool
+
+	// ExpectedAudience, if non-empty, is the DID of the PDS that the access
+	// token must be intended for. The aud claim may be a single string or
+	// an array of strings, in which case ExpectedAudience must be one of them.
+	// Each aud value must be a DID, or ErrAudienceNotDID is returned.
+	ExpectedAudience string
+
+	// RequireEmailConfirmed rejects sessions for accounts whose email address
+	// is not confirmed with ErrEmailNotConfirmed.
+	// The version of indigo used by this package does not include emailConfirmed
+	// in ServerCreateSession_Output, so the confirmation status is only known
+	// when checking a raw response with CheckJSONWith. Otherwise the status is
+	// unknown and the session is rejected.
+	RequireEmailConfirmed bool
+
+	// ExpectedIssuer, if non-empty, must equal the iss claim of the access token.
+	ExpectedIssuer string
+
+	// AllowMasterCredentials downgrades the use of a master password from
+	// an ErrMasterCredentials error to CheckResult.IsMasterCredentials,
+	// as reported by CheckDetailedWith. The remaining checks still apply.
+	// This is intended for tools auditing many stored sessions.
+	AllowMasterCredentials bool
+
+	// AllowNoneAlgorithm permits tokens whose alg header is "none"
+	// when Strict is set. Such tokens are otherwise rejected with
+	// ErrUnsafeAlgorithm, since they carry no signature at all.
+	AllowNoneAlgorithm bool
+
+	// MinRemaining, if positive, rejects sessions whose access token has less
+	// than MinRemaining of its lifetime left with ErrExpiresBeforeDeadline.
+	// Middleware can use this to trigger a refresh proactively rather than
+	// risk using a token that is about to expire.
+	MinRemaining time.Duration
+
+	// Logger, if non-nil, receives a debug-level record of each check
+	// describing the scope, the expiration times, and the outcome.
+	// The tokens themselves are never logged.
+	Logger *slog.Logger
+
+	// RequireValidDID rejects sessions whose Did field or access token
+	// sub claim is not a valid DID with ErrMalformedDID. See ValidateDID.
+	RequireValidDID bool
+
+	// LenientDates accepts an exp claim encoded as an RFC 3339 string,
+	// as emitted by some non-conforming token issuers, in addition to
+	// the numeric dates required by the JWT specification.
+	LenientDates bool
+
+	// RequiredClaims lists application-specific claims that must be present
+	// in the access token. If any are absent, the error matches ErrMissingClaim
+	// and names the missing claims.
+	RequiredClaims []string
+
+	// AllowedRefreshScopes lists the refresh token scopes that are accepted.
+	// If empty, only "com.atproto.refresh" is accepted. Other scopes are
+	// rejected with ErrUnexpectedRefreshScope.
+	AllowedRefreshScopes []string
+
+	// AcceptedTokenTypes lists the typ header values accepted for the
+	// access token when Strict is set. If empty, "JWT" and "at+jwt" are
+	// accepted. A token without a typ header is always accepted.
+	AcceptedTokenTypes []string
+
+	// TreatMissingScopeAs controls how an access token without a scope claim
+	// is handled. The default, MissingScopeError, rejects it with ErrMissingScope.
+	// Strict validation always requires a scope claim.
+	TreatMissingScopeAs MissingScopeMode
+
+	// AllowedDIDs, if non-empty, lists the DIDs of the accounts whose sessions
+	// are accepted. Sessions whose access token sub claim is not in the list
+	// are rejected with ErrDIDNotAllowed.
+	AllowedDIDs []string
+
+	// RequireHandleResolves rejects sessions whose handle does not resolve
+	// to the session's DID with ErrHandleDIDMismatch, using HandleResolver.
+	// Resolution uses the context passed to CheckContext or CheckContextWith.
+	RequireHandleResolves bool
+
+	// HandleResolver resolves handles for RequireHandleResolves.
+	HandleResolver HandleResolver
+
+	// RevokedJTIs is a set of revoked jti claims. Sessions with an access or
+	// refresh token whose jti claim is in the set are rejected with
+	// ErrTokenRevoked, even if unexpired. Tokens without a jti claim are
+	// not checked.
+	RevokedJTIs map[string]struct{}
+
+	// MaxLifetime, if positive, rejects access tokens whose lifetime from
+	// their iat claim to their exp claim exceeds MaxLifetime with
+	// ErrLifetimeTooLong. Tokens without an iat claim are rejected with
+	// ErrMissingIssuedAt. A long-lived access token can indicate a
+	// misconfigured or malicious issuer.
+	MaxLifetime time.Duration
+
+	// AllowedIssuers, if non-empty, lists the accepted values of the access
+	// token's iss claim, returning ErrWrongIssuer for any other issuer.
+	// A pattern of the form "*.example.com" matches any issuer whose host
+	// is a subdomain of example.com, where the host of an issuer is taken
+	// from a did:web DID or an https URL. Other patterns must match exactly.
+	AllowedIssuers []string
+
+	// MinTokenVersion, if non-empty, rejects access tokens whose version
+	// claim is absent or compares as older than MinTokenVersion with ErrTokenTooOld.
+	// The claim is named by TokenVersionClaim and compared with
+	// CompareTokenVersions. The version claim is not yet part of atproto,
+	// so both are configurable.
+	MinTokenVersion string
+
+	// TokenVersionClaim is the name of the claim checked against
+	// MinTokenVersion. If empty, "ver" is used.
+	TokenVersionClaim string
+
+	// CompareTokenVersions, if non-nil, compares the version claim of a token,
+	// formatted as a string, against MinTokenVersion, returning a negative
+	// number if version is older. If nil, versions are compared as
+	// dot-separated numbers, such as "1.10" being newer than "1.9".
+	CompareTokenVersions func(version, min string) int
+
+	// RejectInactiveAccounts rejects sessions for accounts that the PDS has
+	// flagged as not active, such as takendown or deactivated accounts,
+	// with ErrAccountInactive. Access tokens with the takendown scope are
+	// always detected. The version of indigo used by this package does not
+	// include the active and status fields in ServerCreateSession_Output,
+	// so those are only consulted when checking a raw response with CheckJSON.
+	RejectInactiveAccounts bool
+
+	// ExpectedHandle, if non-empty, rejects sessions whose handle does not
+	// match it, ignoring case, with ErrWrongHandle. The session's DID must
+	// also match its access token. Because handles can be renamed, the error
+	// names the session's DID so that a rename can be told apart from the
+	// wrong account; to pin an account regardless of renames, use AllowedDIDs.
+	ExpectedHandle string
+
+	// AuditSink, if non-nil, receives a ValidationEvent for each session
+	// checked, for deployments that keep an audit trail of every decision.
+	AuditSink AuditSink
+
+	// VerifyWith, if non-nil, switches validation to full signature
+	// verification: the signatures of both the access and refresh tokens are
+	// verified with the key it returns, as by VerifySignature, and a token
+	// that fails verification is rejected with ErrInvalidSignature. It may
+	// select a key from a JWKS by the token's kid header, or be StaticKey for
+	// a single known key. Unsigned tokens are always rejected when verifying,
+	// regardless of AllowNoneAlgorithm. If nil, signatures are not verified.
+	VerifyWith jwt.Keyfunc
+
+	// MaxAge, if positive, rejects sessions whose access token was issued,
+	// according to its iat claim, longer ago than MaxAge with ErrSessionTooOld,
+	// regardless of its expiry. This enforces a rotation policy. Because
+	// refreshSession issues tokens with a new iat claim, the age is measured
+	// from the most recent login or refresh. Tokens without an iat claim are
+	// rejected with ErrMissingIssuedAt.
+	MaxAge time.Duration
+}
+
+// MissingScopeMode controls how an access token without a scope claim is
+// handled. Such tokens may come from older deployments.
+type MissingScopeMode int
+
+const (
+	// MissingScopeError rejects the token with an error matching both
+	// ErrLoginUnauthorized and ErrMissingScope. This is the default.
+	MissingScopeError MissingScopeMode = iota
+
+	// MissingScopeAsMaster treats the token as created with a master password,
+	// so it is rejected with ErrMasterCredentials unless
+	// CheckOptions.AllowMasterCredentials is set.
+	MissingScopeAsMaster
+
+	// MissingScopeAsAppPass accepts the token as created with an app password.
+	MissingScopeAsAppPass
+)
+
+// CheckWith is like Check, but performs the validation configured by opts.
+func CheckWith(sess *atproto.ServerCreateSession_Output, opts CheckOptions) error {
+	_, err := inspect(sess, checkConfig{now: time.Now(), CheckOptions: opts})
+	return err
+}
+
+// Option adjusts the validation performed by Check and related functions,
+// as an alternative to CheckOptions for the most common settings.
+type Option func(*checkConfig)
+
+// newConfig returns the configuration for a check at the current time,
+// adjusted by opts.
+func newConfig(opts []Option) checkConfig {
+	cfg := checkConfig{now: time.Now()}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithNow evaluates expiries against now rather than the current
+// wall clock time, which is useful for deterministic tests.
+func WithNow(now time.Time) Option {
+	return func(cfg *checkConfig) { cfg.now = now }
+}
+
+// WithLeeway sets CheckOptions.Leeway.
+func WithLeeway(d time.Duration) Option {
+	return func(cfg *checkConfig) { cfg.Leeway = d }
+}
+
+// WithScopes sets CheckOptions.AllowedScopes.
+func WithScopes(scopes ...string) Option {
+	return func(cfg *checkConfig) { cfg.AllowedScopes = scopes }
+}
+
+// WithLogger sets CheckOptions.Logger.
+func WithLogger(l *slog.Logger) Option {
+	return func(cfg *checkConfig) { cfg.Logger = l }
+}
+
+// WithOptions replaces all CheckOptions, including any set by
+// earlier options, with opts.
+func WithOptions(opts CheckOptions) Option {
+	return func(cfg *checkConfig) { cfg.CheckOptions = opts }
+}
+
+// CheckContextWith is like CheckWith, but accepts a context for checks
+// that make network calls, such as CheckOptions.RequireHandleResolves.
+func CheckContextWith(ctx context.Context, sess *atproto.ServerCreateSession_Output, opts CheckOptions) error {
+	_, err := inspect(sess, checkConfig{ctx: ctx, now: time.Now(), CheckOptions: opts})
+	return err
+}
+
+// CheckWithParser is like Check, but configures the jwt.Parser used for the
+// tokens with opts, such as to restrict the signing methods or to require an
+// audience. See CheckOptions.ParserOptions for how each option applies.
+func CheckWithParser(sess *atproto.ServerCreateSession_Output, opts ...jwt.ParserOption) error {
+	return CheckWith(sess, CheckOptions{ParserOptions: opts})
+}
+
+// CheckAudience is like Check, but additionally requires the access token's
+// aud claim to equal expectedDID, returning ErrWrongAudience otherwise.
+// This prevents sending a session intended for one PDS to another.
+func CheckAudience(sess *atproto.ServerCreateSession_Output, expectedDID string) error {
+	return CheckWith(sess, CheckOptions{ExpectedAudience: expectedDID})
+}
+
+// checkConfig controls the validation performed by inspect.
+type checkConfig struct {
+	CheckOptions
+	ctx   context.Context // nil means context.Background
+	now   time.Time
+	extra sessionExtras // fields only available from a raw response
+
+	// problems, if non-nil, collects every problem found rather than
+	// stopping at the first; see CheckAllProblems and report.
+	problems *[]error
+}
+
+// report handles a problem found by a check. If problems are being
+// collected, err is recorded and report returns nil so that the remaining
+// checks run; otherwise err is returned so that the check fails fast.
+// Checks whose failure prevents later checks from running, such as a parse
+// error, return the result of report immediately. A nil err is ignored.
+func (cfg checkConfig) report(err error) error {
+	if err == nil || cfg.problems == nil {
+		return err
+	}
+	*cfg.problems = append(*cfg.problems, err)
+	return nil
+}
+
+// context returns the context for any network calls made by checks.
+func (cfg checkConfig) context() context.Context {
+	if cfg.ctx == nil {
+		return context.Background()
+	}
+	return cfg.ctx
+}
+
+// CheckIssuer is like Check, but additionally requires the access token's
+// iss claim to equal expectedIssuer, returning ErrWrongIssuer otherwise.
+func CheckIssuer(sess *atproto.ServerCreateSession_Output, expectedIssuer string) error {
+	return CheckWith(sess, CheckOptions{ExpectedIssuer: expectedIssuer})
+}
+
+// CheckWithScopePolicy is like Check, but uses policy to decide whether
+// the scope of the access token is acceptable. See CheckOptions.ScopePolicy.
+func CheckWithScopePolicy(sess *atproto.ServerCreateSession_Output, policy func(scope string) error) error {
+	return CheckWith(sess, CheckOptions{ScopePolicy: policy})
+}
+
+// AppPassScopePolicy is the default scope policy. It accepts the
+// "com.atproto.appPass" scope and the "com.atproto.appPassPrivileged" scope
+// of privileged app passwords, and rejects any other scope with ErrMasterCredentials.
+func AppPassScopePolicy(scope string) error {
+	if !isAppPassScope(scope) {
+		return ErrMasterCredentials
+	}
+	return nil
+}
+
+// checkScope reports whether scope is accepted by cfg.
+func (cfg checkConfig) checkScope(scope string) error {
+	if cfg.ScopePolicy != nil {
+		return cfg.ScopePolicy(scope)
+	}
+	if len(cfg.AllowedScopes) == 0 {
+		return AppPassScopePolicy(scope)
+	}
+	if contains(cfg.AllowedScopes, scope) {
+		return nil
+	}
+	return ErrMasterCredentials
+}
+
+// checkRequiredClaims reports an error naming any of cfg.RequiredClaims
+// that are absent from claims.
+func (cfg checkConfig) checkRequiredClaims(claims jwt.MapClaims) error {
+	var missing []string
+	for _, name := range cfg.RequiredClaims {
+		if _, ok := claims[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrMissingClaim, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// refreshScopeAllowed reports whether the scope claim of a refresh token
+// is accepted by cfg.
+func (cfg checkConfig) refreshScopeAllowed(scope interface{}) bool {
+	s, ok := scope.(string)
+	if !ok {
+		return false
+	}
+	if len(cfg.AllowedRefreshScopes) == 0 {
+		return s == refreshScope
+	}
+	return contains(cfg.AllowedRefreshScopes, s)
+}
+
+// checkRevoked reports whether the jti claim of claims is in cfg.RevokedJTIs.
+func (cfg checkConfig) checkRevoked(claims jwt.MapClaims) error {
+	if len(cfg.RevokedJTIs) == 0 {
+		return nil
+	}
+	jti, ok := claims["jti"].(string)
+	if !ok {
+		return nil
+	}
+	if _, revoked := cfg.RevokedJTIs[jti]; revoked {
+		return fmt.Errorf("%w: jti %q", ErrTokenRevoked, jti)
+	}
+	return nil
+}
+
+// issuerAllowed reports whether iss matches any of patterns.
+// See CheckOptions.AllowedIssuers.
+func issuerAllowed(iss string, patterns []string) bool {
+	host := issuerHost(iss)
+	for _, p := range patterns {
+		if p == iss {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(p, "*"); ok && strings.HasPrefix(suffix, ".") && host != "" {
+			suffix = strings.ToLower(suffix)
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// issuerHost returns the lowercased host of iss if it is a did:web DID
+// or an https URL, or an empty string otherwise. A port is not part
+// of the host. A did:web DID must be valid according to ValidateDID,
+// so that characters such as '/' cannot smuggle a suffix that looks like
+// an allowed host.
+func issuerHost(iss string) string {
+	if id, ok := strings.CutPrefix(iss, "did:web:"); ok {
+		host, port, hasPort := strings.Cut(id, "%3A")
+		host = strings.ToLower(host)
+		did := "did:web:" + host
+		if hasPort {
+			did += "%3A" + port
+		}
+		if ValidateDID(did) != nil {
+			return ""
+		}
+		return host
+	}
+	u, err := url.Parse(iss)
+	if err != nil || u.Scheme != "https" {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// checkTokenVersion checks the version claim of claims against cfg.MinTokenVersion.
+func (cfg checkConfig) checkTokenVersion(claims jwt.MapClaims) error {
+	if cfg.MinTokenVersion == "" {
+		return nil
+	}
+	name := cfg.TokenVersionClaim
+	if name == "" {
+		name = "ver"
+	}
+	raw, ok := claims[name]
+	if !ok || raw == nil {
+		return fmt.Errorf("%w: missing %s claim", ErrTokenTooOld, name)
+	}
+	version := fmt.Sprint(raw)
+	compare := cfg.CompareTokenVersions
+	if compare == nil {
+		compare = compareVersions
+	}
+	if compare(version, cfg.MinTokenVersion) < 0 {
+		return fmt.Errorf("%w: version %s is older than %s", ErrTokenTooOld, version, cfg.MinTokenVersion)
+	}
+	return nil
+}
+
+// compareVersions compares dot-separated versions a and b, returning
+// a negative number if a is older, zero if equal, and a positive number
+// if a is newer. Numeric components compare numerically, and other
+// components compare as strings. Missing components count as zero.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		x, y := "0", "0"
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		xn, xerr := strconv.ParseUint(x, 10, 64)
+		yn, yerr := strconv.ParseUint(y, 10, 64)
+		switch {
+		case xerr == nil && yerr == nil && xn != yn:
+			if xn < yn {
+				return -1
+			}
+			return 1
+		case (xerr != nil || yerr != nil) && x != y:
+			return strings.Compare(x, y)
+		}
+	}
+	return 0
+}
+
+// contains reports whether list contains s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// notYetValid reports whether a token with the not before time nbf
+// is not yet valid, allowing for cfg.Leeway. A zero nbf is always valid.
+func (cfg checkConfig) notYetValid(nbf time.Time) bool {
+	return !nbf.IsZero() && cfg.now.Add(cfg.Leeway).Before(nbf) && !cfg.parserAccepts("nbf", nbf, jwt.ErrTokenNotValidYet)
+}
+
+// expired reports whether a token with the expiration time exp has
+// expired, allowing for cfg.Leeway.
+func (cfg checkConfig) expired(exp time.Time) bool {
+	return exp.Add(cfg.Leeway).Before(cfg.now) && !cfg.parserAccepts("exp", exp, jwt.ErrTokenExpired)
+}
+
+// parserAccepts reports whether the jwt validation configured by
+// cfg.ParserOptions accepts the time t of the named claim, such as with a
+// wider jwt.WithLeeway than cfg.Leeway. kind is the error the jwt package
+// reports if it does not. Without ParserOptions, it always reports false.
+func (cfg checkConfig) parserAccepts(name string, t time.Time, kind error) bool {
+	if len(cfg.ParserOptions) == 0 {
+		return false
+	}
+	err := cfg.validateClaims(jwt.MapClaims{name: float64(t.UnixNano()) / 1e9})
+	return !errors.Is(err, kind)
+}
+
+// expiration is like the expiration function, but if cfg.LenientDates
+// is set it also accepts an exp claim that is an RFC 3339 string.
+func (cfg checkConfig) expiration(claims jwt.MapClaims) (time.Time, error) {
+	s, ok := claims["exp"].(string)
+	if !cfg.LenientDates || !ok {
+		return expiration(claims)
+	}
+	exp, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: exp claim is neither a numeric date nor an RFC 3339 string", ErrMalformedToken)
+	}
+	return exp, nil
+}
+
+// parseClaims parses tokenString with the parser configured by cfg.
+func (cfg checkConfig) parseClaims(tokenString string) (jwt.MapClaims, error) {
+	_, claims, err := cfg.parseToken(tokenString)
+	return claims, err
+}
+
+// parseToken is like parseClaims, but also returns the token.
+// The signature is verified if cfg.VerifyWith is set.
+func (cfg checkConfig) parseToken(tokenString string) (*jwt.Token, jwt.MapClaims, error) {
+	parser := newParser(cfg.ParserOptions...)
+	token, claims, err := parseTokenWith(parser, tokenString)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(cfg.ParserOptions) > 0 {
+		if err := cfg.applyParserOptions(parser, token, claims); err != nil {
+			return nil, nil, err
+		}
+	}
+	if cfg.VerifyWith != nil {
+		if err := verifyToken(tokenString, token.Header, cfg.VerifyWith, cfg.ParserOptions...); err != nil {
+			return nil, nil, err
+		}
+	}
+	return token, claims, nil
+}
+
+// errMethodAccepted is returned by the Keyfunc with which applyParserOptions
+// has the jwt package check the signing method of a token.
+var errMethodAccepted = errors.New("signing method accepted")
+
+// applyParserOptions applies the checks configured by cfg.ParserOptions
+// to a token that parser has already decoded. The jwt package only checks
+// the signing method and validates the claims of tokens whose signature it
+// verifies, so both are requested from it here without a key.
+// The exp and nbf claims are left to cfg.expired and cfg.notYetValid.
+func (cfg checkConfig) applyParserOptions(parser *jwt.Parser, token *jwt.Token, claims jwt.MapClaims) error {
+	_, err := parser.Parse(token.Raw, func(*jwt.Token) (interface{}, error) { return nil, errMethodAccepted })
+	if !errors.Is(err, errMethodAccepted) {
+		return fmt.Errorf("%w: signing method %v is not allowed by the parser options", ErrUnexpectedAlgorithm, token.Header["alg"])
+	}
+	err = cfg.validateClaims(claims)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return fmt.Errorf("%w: %w", ErrWrongAudience, err)
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return fmt.Errorf("%w: %w", ErrWrongIssuer, err)
+	case errors.Is(err, jwt.ErrTokenInvalidSubject):
+		return fmt.Errorf("%w: %w", ErrDIDNotAllowed, err)
+	case errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return fmt.Errorf("%w: %w", ErrTokenFromFuture, err)
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return fmt.Errorf("%w: %w", ErrMissingClaim, err)
+	case errors.Is(err, jwt.ErrTokenExpired), errors.Is(err, jwt.ErrTokenNotValidYet):
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrMalformedToken, err)
+}
+
+// unsignedHeader is the encoded header of an unsigned JWT.
+var unsignedHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+
+// validateClaims validates claims with the jwt package, configured by
+// cfg.ParserOptions. The jwt package only validates the claims of a token
+// whose signature it has verified, so the claims are re-encoded as an
+// unsigned token, which it verifies only when explicitly asked to.
+func (cfg checkConfig) validateClaims(claims jwt.MapClaims) error {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return err
+	}
+	unsigned := unsignedHeader + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+	now := cfg.now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	opts := append([]jwt.ParserOption{jwt.WithTimeFunc(func() time.Time { return now })}, cfg.ParserOptions...)
+	opts = append(opts, jwt.WithValidMethods([]string{jwt.SigningMethodNone.Alg()}))
+	_, err = newParser(opts...).Parse(unsigned, func(*jwt.Token) (interface{}, error) {
+		return jwt.UnsafeAllowNoneSignatureType, nil
+	})
+	return err
+}
+
+// logResult logs the outcome of a check to cfg.Logger.
+func (cfg checkConfig) logResult(res CheckResult, err error) {
+	attrs := []slog.Attr{
+		slog.String("scope", res.Scope),
+		slog.Time("accessExpiry", res.AccessExpiry),
+		slog.Time("refreshExpiry", res.RefreshExpiry),
+		slog.String("outcome", Classify(err).String()),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	cfg.Logger.LogAttrs(cfg.context(), slog.LevelDebug, "appkey: checked session", attrs...)
+}
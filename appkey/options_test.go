@@ -0,0 +1,126 @@
+package appkey
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/thepudds/bluesky-aux/appkey/appkeytest"
+)
+
+func TestIssuerAllowed(t *testing.T) {
+	patterns := []string{"*.host.bsky.network", "did:web:pds.example.com", "https://exact.example"}
+	tests := []struct {
+		iss  string
+		want bool
+	}{
+		// Wildcards.
+		{"did:web:morel.us-east.host.bsky.network", true},
+		{"did:web:MOREL.us-east.host.bsky.network", true},
+		{"https://morel.us-east.host.bsky.network", true},
+		{"https://morel.us-east.host.bsky.network/path", true},
+		{"did:web:host.bsky.network", false},
+		{"did:web:evilhost.bsky.network", false},
+		{"http://morel.host.bsky.network", false},
+
+		// Exact matches.
+		{"did:web:pds.example.com", true},
+		{"https://exact.example", true},
+		{"did:web:other.example.com", false},
+		{"https://sub.exact.example", false},
+
+		// did:web with a port.
+		{"did:web:morel.host.bsky.network%3A8080", true},
+		{"did:web:morel.host.bsky.network%3A", false},
+		{"did:web:morel.host.bsky.network%3Ax", false},
+
+		// Path and other injection attempts.
+		{"did:web:evil.example/x.host.bsky.network", false},
+		{"did:web:evil.example#.host.bsky.network", false},
+		{"did:web:evil.example?.host.bsky.network", false},
+		{"did:web:evil.example:x.host.bsky.network", false},
+		{"did:web:evil.example%2Fx.host.bsky.network", false},
+		{"https://evil.example/x.host.bsky.network", false},
+		{"https://evil.example#.host.bsky.network", false},
+		{"https://x.host.bsky.network@evil.example", false},
+	}
+	for _, tt := range tests {
+		if got := issuerAllowed(tt.iss, patterns); got != tt.want {
+			t.Errorf("issuerAllowed(%q) = %v, want %v", tt.iss, got, tt.want)
+		}
+	}
+}
+
+func TestCheckWithAllowedIssuers(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	opts := CheckOptions{AllowedIssuers: []string{"*.host.bsky.network"}}
+	for _, tt := range []struct {
+		iss  string
+		want error
+	}{
+		{"did:web:morel.us-east.host.bsky.network", nil},
+		{"did:web:evil.example/x.host.bsky.network", ErrWrongIssuer},
+	} {
+		sess := appkeytest.NewSession("did:plc:abcdefghijklmnopqrstuvwx", "alice.test", appkeytest.AppPassScope, exp)
+		access := appkeytest.AppPass(sess.Did, exp)
+		access.Issuer = tt.iss
+		sess.AccessJwt = access.Encode()
+		if err := CheckWith(sess, opts); !errors.Is(err, tt.want) {
+			t.Errorf("CheckWith with iss %q = %v, want %v", tt.iss, err, tt.want)
+		}
+	}
+}
+
+func TestCheckWithParser(t *testing.T) {
+	now := time.Now()
+	sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, now.Add(time.Hour))
+	expired := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, now.Add(-time.Minute))
+	future := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, now.Add(time.Hour))
+	access := appkeytest.AppPass(testDID, now.Add(time.Hour))
+	access.Audience, access.Issuer, access.IssuedAt = appkeytest.PDSDID, appkeytest.PDSDID, now.Add(time.Minute)
+	future.AccessJwt = access.Encode()
+
+	tests := []struct {
+		name string
+		sess *atproto.ServerCreateSession_Output
+		opts []jwt.ParserOption
+		want error
+	}{
+		{"no options", sess, nil, nil},
+		{"allowed method", sess, []jwt.ParserOption{jwt.WithValidMethods([]string{"HS256"})}, nil},
+		{"disallowed method", sess, []jwt.ParserOption{jwt.WithValidMethods([]string{"ES256"})}, ErrUnexpectedAlgorithm},
+		{"expected audience", sess, []jwt.ParserOption{jwt.WithAudience(appkeytest.PDSDID)}, nil},
+		{"wrong audience", sess, []jwt.ParserOption{jwt.WithAudience("did:web:other.example.com")}, ErrWrongAudience},
+		{"wrong issuer", sess, []jwt.ParserOption{jwt.WithIssuer("did:web:other.example.com")}, ErrWrongIssuer},
+		{"wrong subject", sess, []jwt.ParserOption{jwt.WithSubject("did:plc:zzzzzzzzzzzzzzzzzzzzzzzz")}, ErrDIDNotAllowed},
+		{"expired", expired, nil, ErrSessionExpired},
+		{"expired within parser leeway", expired, []jwt.ParserOption{jwt.WithLeeway(time.Hour)}, nil},
+		{"expired beyond parser leeway", expired, []jwt.ParserOption{jwt.WithLeeway(time.Second)}, ErrSessionExpired},
+		{"parser time func", sess, []jwt.ParserOption{jwt.WithTimeFunc(func() time.Time { return now.Add(2 * time.Hour) })}, nil},
+		{"future iat ignored", future, []jwt.ParserOption{jwt.WithJSONNumber()}, nil},
+		{"future iat", future, []jwt.ParserOption{jwt.WithIssuedAt()}, ErrTokenFromFuture},
+		{"future iat within parser leeway", future, []jwt.ParserOption{jwt.WithIssuedAt(), jwt.WithLeeway(time.Hour)}, nil},
+	}
+	for _, tt := range tests {
+		err := CheckWithParser(tt.sess, tt.opts...)
+		if !errors.Is(err, tt.want) || (tt.want == nil) != (err == nil) {
+			t.Errorf("%s: CheckWithParser = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// The options also apply when verifying signatures.
+	opts := CheckOptions{VerifyWith: StaticKey(appkeytest.Key), ParserOptions: []jwt.ParserOption{jwt.WithValidMethods([]string{"ES256"})}}
+	if err := CheckWith(sess, opts); !errors.Is(err, ErrUnexpectedAlgorithm) {
+		t.Errorf("CheckWith verifying a disallowed method = %v, want ErrUnexpectedAlgorithm", err)
+	}
+	opts.ParserOptions = []jwt.ParserOption{jwt.WithValidMethods([]string{"HS256"}), jwt.WithAudience(appkeytest.PDSDID)}
+	if err := CheckWith(sess, opts); err != nil {
+		t.Errorf("CheckWith verifying an allowed method = %v", err)
+	}
+	// Time based checks still use WithNow.
+	if err := Check(sess, WithNow(now.Add(2*time.Hour)), WithOptions(CheckOptions{ParserOptions: []jwt.ParserOption{jwt.WithJSONNumber()}})); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("Check after expiry with parser options = %v, want ErrSessionExpired", err)
+	}
+}
@@ -0,0 +1,34 @@
+package appkey
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrAppPasswordFormat is returned if a password does not look like
+// a Bluesky app password.
+var ErrAppPasswordFormat = errors.New("not in app password format")
+
+// ValidateAppPasswordFormat checks that pw looks like a Bluesky app password,
+// which is four groups of four lowercase letters or digits separated by hyphens,
+// such as "abcd-efgh-ijkl-mnop".
+// This is a purely local check that can catch a master password
+// before attempting a login. It does not guarantee the server will accept pw.
+func ValidateAppPasswordFormat(pw string) error {
+	if len(pw) != 19 {
+		return fmt.Errorf("%w: expected 19 characters, got %d", ErrAppPasswordFormat, len(pw))
+	}
+	for i := 0; i < len(pw); i++ {
+		c := pw[i]
+		if i%5 == 4 {
+			if c != '-' {
+				return fmt.Errorf("%w: expected '-' at position %d", ErrAppPasswordFormat, i+1)
+			}
+			continue
+		}
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9') {
+			return fmt.Errorf("%w: unexpected character at position %d", ErrAppPasswordFormat, i+1)
+		}
+	}
+	return nil
+}
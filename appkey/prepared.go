@@ -0,0 +1,61 @@
+package appkey
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// PreparedSession holds the result of validating a session once, so that
+// a service checking the same session repeatedly, such as on every request,
+// can skip parsing its tokens each time. Create one with Prepare.
+// A PreparedSession is safe for concurrent use.
+type PreparedSession struct {
+	res          CheckResult
+	leeway       time.Duration
+	minRemaining time.Duration
+	ignoreExpiry bool
+}
+
+// Prepare validates sess as by Check and, if it is valid, returns a
+// PreparedSession recording its expiration times. The options are applied
+// as by Check, and Leeway, MinRemaining, and IgnoreExpiry also apply to
+// later calls to PreparedSession.Check.
+func Prepare(sess *atproto.ServerCreateSession_Output, opts ...Option) (*PreparedSession, error) {
+	cfg := newConfig(opts)
+	res, err := inspect(sess, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &PreparedSession{
+		res:          res,
+		leeway:       cfg.Leeway,
+		minRemaining: cfg.MinRemaining,
+		ignoreExpiry: cfg.IgnoreExpiry,
+	}, nil
+}
+
+// Check reports whether the prepared session is still valid at now.
+// Only the expiration times recorded by Prepare are compared, so Check
+// performs no parsing and does not allocate unless it returns an error.
+// The errors match those of Check for an expired session.
+func (p *PreparedSession) Check(now time.Time) error {
+	if p.ignoreExpiry {
+		return nil
+	}
+	switch {
+	case p.res.AccessExpiry.Add(p.leeway).Before(now):
+		return &SessionExpiredError{Token: "access", AccessExpiry: p.res.AccessExpiry, RefreshExpiry: p.res.RefreshExpiry}
+	case p.res.RefreshExpiry.Add(p.leeway).Before(now):
+		return &SessionExpiredError{Token: "refresh", AccessExpiry: p.res.AccessExpiry, RefreshExpiry: p.res.RefreshExpiry}
+	case p.minRemaining > 0 && p.res.AccessExpiry.Before(now.Add(p.minRemaining)):
+		return fmt.Errorf("%w: access token expires at %v, less than %v from now", ErrExpiresBeforeDeadline, p.res.AccessExpiry, p.minRemaining)
+	}
+	return nil
+}
+
+// Result returns the CheckResult recorded when the session was prepared.
+func (p *PreparedSession) Result() CheckResult {
+	return p.res
+}
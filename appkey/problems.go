@@ -0,0 +1,23 @@
+package appkey
+
+import (
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// CheckAllProblems performs the same validation as Check, but rather than
+// stopping at the first problem it continues with the remaining checks and
+// returns every problem found, joined with errors.Join, so that audit tools
+// can report everything wrong with a session in a single pass. For example,
+// the result may match both ErrSessionExpired and ErrMasterCredentials.
+//
+// Some problems prevent later checks from running: if a token cannot be
+// parsed, or a session has no access or refresh token, the checks that depend
+// on it are skipped. Likewise, if the access and refresh tokens are
+// identical, only the access token is validated after ErrIdenticalTokens is
+// reported. Check itself still returns only the first problem.
+func CheckAllProblems(sess *atproto.ServerCreateSession_Output, opts ...Option) error {
+	cfg := newConfig(opts)
+	cfg.problems = new([]error)
+	_, err := inspect(sess, cfg)
+	return err
+}
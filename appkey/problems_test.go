@@ -0,0 +1,71 @@
+package appkey
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/thepudds/bluesky-aux/appkey/appkeytest"
+)
+
+func TestCheckAllProblemsStrict(t *testing.T) {
+	now := time.Now()
+	exp := now.Add(time.Hour)
+
+	// An access token missing sub and iss, with a bad aud, should report
+	// each problem rather than only the first.
+	access := appkeytest.Token{Scope: appkeytest.AppPassScope, Audience: "pds.example.com", IssuedAt: now.Add(-time.Minute), ExpiresAt: exp}
+	sess := &atproto.ServerCreateSession_Output{
+		Did:        testDID,
+		Handle:     "alice.test",
+		AccessJwt:  access.Encode(),
+		RefreshJwt: appkeytest.Refresh("", now.Add(24*time.Hour)).Encode(),
+	}
+	err := CheckAllProblems(sess, WithOptions(CheckOptions{Strict: true}))
+	for _, want := range []error{ErrMissingClaim, ErrAudienceNotDID} {
+		if !errors.Is(err, want) {
+			t.Errorf("CheckAllProblems = %v; want match for %v", err, want)
+		}
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n < 3 {
+		t.Errorf("CheckAllProblems reported %d problems, want at least 3 (sub, iss, aud): %v", n, err)
+	}
+
+	// A refresh token without exp has no expiry to compare with the
+	// access token's, so only the missing exp is reported.
+	refresh := appkeytest.Refresh(testDID, time.Time{})
+	sess = appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, exp)
+	sess.RefreshJwt = refresh.Encode()
+	err = CheckAllProblems(sess, WithOptions(CheckOptions{Strict: true}))
+	if !errors.Is(err, ErrMissingExpiration) {
+		t.Errorf("CheckAllProblems = %v; want ErrMissingExpiration", err)
+	}
+	if errors.Is(err, ErrRefreshShorterThanAccess) {
+		t.Errorf("CheckAllProblems = %v; want no ErrRefreshShorterThanAccess", err)
+	}
+}
+
+func TestCheckAllProblemsIdenticalTokens(t *testing.T) {
+	exp := time.Now().Add(-time.Minute)
+	sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.MasterScope, exp)
+	sess.RefreshJwt = sess.AccessJwt
+	if err := Check(sess); !errors.Is(err, ErrIdenticalTokens) {
+		t.Errorf("Check = %v, want ErrIdenticalTokens", err)
+	}
+
+	// The access token is still validated, but the refresh token is not.
+	err := CheckAllProblems(sess)
+	for _, want := range []error{ErrIdenticalTokens, ErrMasterCredentials, ErrSessionExpired} {
+		if !errors.Is(err, want) {
+			t.Errorf("CheckAllProblems = %v; want match for %v", err, want)
+		}
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 3 {
+		t.Errorf("CheckAllProblems reported %d problems, want 3: %v", n, err)
+	}
+	var expired *SessionExpiredError
+	if errors.As(err, &expired) && (expired.Token != "access" || !expired.RefreshExpiry.IsZero()) {
+		t.Errorf("SessionExpiredError = %+v, want the access token with no refresh expiry", expired)
+	}
+}
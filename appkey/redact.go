@@ -0,0 +1,32 @@
+package appkey
+
+import (
+	"fmt"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// redactedSuffixLen is the number of trailing token characters
+// kept by redact, which is enough to tell tokens apart in logs.
+const redactedSuffixLen = 4
+
+// SafeString renders sess for logging, with the access and refresh tokens
+// redacted to their last few characters.
+func SafeString(sess *atproto.ServerCreateSession_Output) string {
+	if sess == nil {
+		return "<nil session>"
+	}
+	return fmt.Sprintf("did=%s handle=%s accessJwt=%s refreshJwt=%s",
+		sess.Did, sess.Handle, redact(sess.AccessJwt), redact(sess.RefreshJwt))
+}
+
+// redact returns a form of token that is safe to log.
+func redact(token string) string {
+	if token == "" {
+		return `""`
+	}
+	if len(token) <= 2*redactedSuffixLen {
+		return "[redacted]"
+	}
+	return "[redacted]..." + token[len(token)-redactedSuffixLen:]
+}
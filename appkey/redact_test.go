@@ -0,0 +1,104 @@
+package appkey
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/thepudds/bluesky-aux/appkey/appkeytest"
+)
+
+func TestErrorsOmitTokens(t *testing.T) {
+	now := time.Now()
+	exp := now.Add(time.Hour)
+	session := func(scope string, exp time.Time) *atproto.ServerCreateSession_Output {
+		return appkeytest.NewSession(testDID, "alice.test", scope, exp)
+	}
+	with := func(sess *atproto.ServerCreateSession_Output, access, refresh string) *atproto.ServerCreateSession_Output {
+		if access != "" {
+			sess.AccessJwt = access
+		}
+		if refresh != "" {
+			sess.RefreshJwt = refresh
+		}
+		return sess
+	}
+	wrongSub := appkeytest.Refresh("did:plc:zzzzzzzzzzzzzzzzzzzzzzzz", now.Add(24*time.Hour)).Encode()
+	wrongScope := appkeytest.Refresh(testDID, now.Add(24*time.Hour))
+	wrongScope.Scope = appkeytest.AppPassScope
+	notYet := appkeytest.AppPass(testDID, exp)
+	notYet.NotBefore = now.Add(time.Minute * 30)
+
+	tests := []struct {
+		name  string
+		sess  *atproto.ServerCreateSession_Output
+		check func(*atproto.ServerCreateSession_Output) error
+	}{
+		{"master", session(appkeytest.MasterScope, exp), func(s *atproto.ServerCreateSession_Output) error { return Check(s) }},
+		{"expired", session(appkeytest.AppPassScope, now.Add(-time.Hour)), func(s *atproto.ServerCreateSession_Output) error { return Check(s) }},
+		{"missing scope", session("", exp), func(s *atproto.ServerCreateSession_Output) error { return Check(s) }},
+		{"wrong refresh scope", with(session(appkeytest.AppPassScope, exp), "", wrongScope.Encode()), func(s *atproto.ServerCreateSession_Output) error { return Check(s) }},
+		{"subject mismatch", with(session(appkeytest.AppPassScope, exp), "", wrongSub), func(s *atproto.ServerCreateSession_Output) error { return Check(s) }},
+		{"not yet valid", with(session(appkeytest.AppPassScope, exp), notYet.Encode(), ""), func(s *atproto.ServerCreateSession_Output) error { return Check(s) }},
+		{"identical tokens", session(appkeytest.AppPassScope, exp), func(s *atproto.ServerCreateSession_Output) error {
+			return CheckTokens(s.AccessJwt, s.AccessJwt)
+		}},
+		{"truncated", session(appkeytest.AppPassScope, exp), func(s *atproto.ServerCreateSession_Output) error {
+			s.AccessJwt = s.AccessJwt[:len(s.AccessJwt)/2]
+			return Check(s)
+		}},
+		{"wrong audience", session(appkeytest.AppPassScope, exp), func(s *atproto.ServerCreateSession_Output) error {
+			return CheckAudience(s, "did:web:other.example.com")
+		}},
+		{"wrong issuer", session(appkeytest.AppPassScope, exp), func(s *atproto.ServerCreateSession_Output) error {
+			return CheckIssuer(s, "did:web:other.example.com")
+		}},
+		{"bad signature", session(appkeytest.AppPassScope, exp), func(s *atproto.ServerCreateSession_Output) error {
+			return CheckWith(s, CheckOptions{VerifyWith: StaticKey([]byte("wrong key"))})
+		}},
+		{"required claim", session(appkeytest.AppPassScope, exp), func(s *atproto.ServerCreateSession_Output) error {
+			return CheckWith(s, CheckOptions{RequiredClaims: []string{"email"}})
+		}},
+		{"all problems", session(appkeytest.MasterScope, now.Add(-time.Hour)), func(s *atproto.ServerCreateSession_Output) error {
+			return CheckAllProblems(s, WithOptions(CheckOptions{Strict: true}))
+		}},
+		{"refresh only", with(session(appkeytest.AppPassScope, exp), "", appkeytest.Refresh(testDID, now.Add(-time.Hour)).Encode()), func(s *atproto.ServerCreateSession_Output) error {
+			return CheckRefreshOnly(s.RefreshJwt)
+		}},
+		{"refresh only with access token", session(appkeytest.AppPassScope, exp), func(s *atproto.ServerCreateSession_Output) error {
+			return CheckRefreshOnly(s.AccessJwt)
+		}},
+		{"scope only", session(appkeytest.MasterScope, exp), func(s *atproto.ServerCreateSession_Output) error { return CheckScopeOnly(s) }},
+	}
+	for _, tt := range tests {
+		access, refresh := tt.sess.AccessJwt, tt.sess.RefreshJwt
+		err := tt.check(tt.sess)
+		if err == nil {
+			t.Errorf("%s: got no error", tt.name)
+			continue
+		}
+		msg := err.Error()
+		for _, tok := range []string{access, refresh} {
+			if strings.Contains(msg, tok) {
+				t.Errorf("%s: error contains a token: %v", tt.name, err)
+			}
+			for _, seg := range strings.Split(tok, ".")[1:] {
+				if strings.Contains(msg, seg) {
+					t.Errorf("%s: error contains a token segment %q: %v", tt.name, seg, err)
+				}
+			}
+		}
+	}
+}
+
+func TestSafeStringOmitsTokens(t *testing.T) {
+	sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, time.Now().Add(time.Hour))
+	s := SafeString(sess)
+	if strings.Contains(s, sess.AccessJwt) || strings.Contains(s, sess.RefreshJwt) {
+		t.Errorf("SafeString = %q, contains a token", s)
+	}
+	if !strings.Contains(s, testDID) {
+		t.Errorf("SafeString = %q, want it to include the DID", s)
+	}
+}
@@ -0,0 +1,83 @@
+package appkey
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// CheckRefreshOutput validates the output of com.atproto.server.refreshSession
+// in the same way that Check validates the output of createSession.
+func CheckRefreshOutput(out *atproto.ServerRefreshSession_Output, opts ...Option) error {
+	if out == nil {
+		return ErrNilSession
+	}
+	return Check(fromRefreshOutput(out), opts...)
+}
+
+// fromRefreshOutput converts the output of refreshSession to the
+// equivalent createSession output.
+func fromRefreshOutput(out *atproto.ServerRefreshSession_Output) *atproto.ServerCreateSession_Output {
+	return &atproto.ServerCreateSession_Output{
+		AccessJwt:  out.AccessJwt,
+		RefreshJwt: out.RefreshJwt,
+		Did:        out.Did,
+		Handle:     out.Handle,
+	}
+}
+
+// ApplyRefresh updates sess in place with the tokens from refreshed, the
+// output of com.atproto.server.refreshSession, preserving fields such as
+// Email that refreshSession does not return. The refreshed session is
+// validated with Check and must be for the same DID as sess, otherwise
+// ErrDIDMismatch is returned. On error, sess is not modified.
+func ApplyRefresh(sess *atproto.ServerCreateSession_Output, refreshed *atproto.ServerRefreshSession_Output) error {
+	if sess == nil || refreshed == nil {
+		return ErrNilSession
+	}
+	if refreshed.Did != sess.Did {
+		return fmt.Errorf("%w: session has %q, refreshed session has %q", ErrDIDMismatch, sess.Did, refreshed.Did)
+	}
+	updated := Clone(sess)
+	updated.AccessJwt = refreshed.AccessJwt
+	updated.RefreshJwt = refreshed.RefreshJwt
+	if refreshed.Handle != "" {
+		updated.Handle = refreshed.Handle
+	}
+	if err := Check(updated); err != nil {
+		return err
+	}
+	if _, err := DID(updated); err != nil {
+		return err
+	}
+	*sess = *updated
+	return nil
+}
+
+// CanRefresh reports whether calling com.atproto.server.refreshSession with
+// sess is worth attempting, which is when its refresh token is present,
+// well-formed, correctly scoped, and unexpired. Otherwise it reports false
+// with a short human-readable reason, such as "refresh token expired",
+// indicating that a new login is needed instead.
+// The error is reserved for a nil session.
+func CanRefresh(sess *atproto.ServerCreateSession_Output) (ok bool, reason string, err error) {
+	if sess == nil {
+		return false, "", ErrNilSession
+	}
+	_, _, err = checkRefreshToken(sess.RefreshJwt, newConfig(nil))
+	switch {
+	case err == nil:
+		return true, "", nil
+	case errors.Is(err, ErrMissingRefreshToken):
+		return false, "refresh token missing", nil
+	case errors.Is(err, ErrSessionExpired):
+		return false, "refresh token expired", nil
+	case errors.Is(err, ErrUnexpectedRefreshScope):
+		return false, "refresh token has the wrong scope", nil
+	case errors.Is(err, ErrTokenNotYetValid):
+		return false, "refresh token not yet valid", nil
+	default:
+		return false, "refresh token malformed", nil
+	}
+}
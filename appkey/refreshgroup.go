@@ -0,0 +1,73 @@
+package appkey
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// RefreshGroup deduplicates concurrent refreshes of the same account's
+// session, so that many goroutines noticing an expired session at once
+// trigger a single call to refreshSession rather than a stampede.
+// The zero value is ready to use. A RefreshGroup must not be copied
+// after first use.
+type RefreshGroup struct {
+	mu    sync.Mutex
+	calls map[string]*refreshCall // keyed by DID
+}
+
+// refreshCall is a refresh that is in progress or has completed.
+type refreshCall struct {
+	done chan struct{}
+	sess *atproto.ServerCreateSession_Output
+	err  error
+}
+
+// Do calls refreshFn and validates the session it returns with Check,
+// making sure that only one refresh for did is in flight at a time.
+// If a refresh for did is already in progress, Do waits for it and returns
+// its result instead of calling refreshFn. The refreshed session must be for
+// did, otherwise ErrDIDMismatch is returned. Each caller receives its own
+// copy of the session, as by Clone.
+func (g *RefreshGroup) Do(did string, refreshFn func() (*atproto.ServerCreateSession_Output, error)) (*atproto.ServerCreateSession_Output, error) {
+	g.mu.Lock()
+	if c, ok := g.calls[did]; ok {
+		g.mu.Unlock()
+		<-c.done
+		return Clone(c.sess), c.err
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*refreshCall)
+	}
+	// The error is replaced once refreshFn returns, so that waiters
+	// do not receive a nil session and error if refreshFn panics.
+	c := &refreshCall{done: make(chan struct{}), err: errors.New("appkey: refresh panicked")}
+	g.calls[did] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, did)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.sess, c.err = refreshAndCheck(did, refreshFn)
+	return Clone(c.sess), c.err
+}
+
+// refreshAndCheck calls refreshFn and validates its result for Do.
+func refreshAndCheck(did string, refreshFn func() (*atproto.ServerCreateSession_Output, error)) (*atproto.ServerCreateSession_Output, error) {
+	sess, err := refreshFn()
+	if err != nil {
+		return nil, err
+	}
+	if err := Check(sess); err != nil {
+		return nil, err
+	}
+	if sess.Did != did {
+		return nil, fmt.Errorf("%w: expected %q, refreshed session has %q", ErrDIDMismatch, did, sess.Did)
+	}
+	return sess, nil
+}
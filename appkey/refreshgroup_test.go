@@ -0,0 +1,138 @@
+package appkey
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/thepudds/bluesky-aux/appkey/appkeytest"
+)
+
+// doConcurrently calls g.Do for testDID from n goroutines at once with
+// refreshFn, and returns the session and error each received, along with
+// the value of any panic. refreshFn is only allowed to return once every
+// goroutine has started, so that they all share one refresh.
+func doConcurrently(g *RefreshGroup, n int, refreshFn func() (*atproto.ServerCreateSession_Output, error)) (sessions []*atproto.ServerCreateSession_Output, errs []error, panics []any) {
+	var started, done sync.WaitGroup
+	started.Add(n)
+	var mu sync.Mutex
+	fn := func() (*atproto.ServerCreateSession_Output, error) {
+		started.Wait()
+		// Give the other goroutines time to reach Do after starting.
+		time.Sleep(20 * time.Millisecond)
+		return refreshFn()
+	}
+	for i := 0; i < n; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			defer func() {
+				if p := recover(); p != nil {
+					mu.Lock()
+					panics = append(panics, p)
+					mu.Unlock()
+				}
+			}()
+			started.Done()
+			sess, err := g.Do(testDID, fn)
+			mu.Lock()
+			sessions = append(sessions, sess)
+			errs = append(errs, err)
+			mu.Unlock()
+		}()
+	}
+	done.Wait()
+	return sessions, errs, panics
+}
+
+func TestRefreshGroupShared(t *testing.T) {
+	var g RefreshGroup
+	var calls atomic.Int32
+	fresh := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, time.Now().Add(time.Hour))
+	sessions, errs, panics := doConcurrently(&g, 10, func() (*atproto.ServerCreateSession_Output, error) {
+		calls.Add(1)
+		return fresh, nil
+	})
+	if n := calls.Load(); n != 1 {
+		t.Errorf("refreshFn called %d times, want 1", n)
+	}
+	if len(panics) != 0 {
+		t.Fatalf("Do panicked: %v", panics)
+	}
+	seen := make(map[*atproto.ServerCreateSession_Output]bool)
+	for i, sess := range sessions {
+		if errs[i] != nil {
+			t.Errorf("Do: %v", errs[i])
+			continue
+		}
+		if sess.AccessJwt != fresh.AccessJwt {
+			t.Errorf("Do returned a different session")
+		}
+		if seen[sess] || sess == fresh {
+			t.Errorf("Do returned a shared session, want a copy for each caller")
+		}
+		seen[sess] = true
+	}
+
+	// Once the refresh completes, the next call refreshes again.
+	if _, err := g.Do(testDID, func() (*atproto.ServerCreateSession_Output, error) {
+		calls.Add(1)
+		return fresh, nil
+	}); err != nil || calls.Load() != 2 {
+		t.Errorf("Do after completion = %v with %d calls, want a new refresh", err, calls.Load())
+	}
+}
+
+func TestRefreshGroupError(t *testing.T) {
+	var g RefreshGroup
+	errRefresh := errors.New("refresh failed")
+	sessions, errs, panics := doConcurrently(&g, 10, func() (*atproto.ServerCreateSession_Output, error) {
+		return nil, errRefresh
+	})
+	if len(panics) != 0 {
+		t.Fatalf("Do panicked: %v", panics)
+	}
+	for i, err := range errs {
+		if !errors.Is(err, errRefresh) || sessions[i] != nil {
+			t.Errorf("Do = %v, %v; want nil, the refresh error", sessions[i], err)
+		}
+	}
+
+	// A refreshed session that fails validation is reported to every caller.
+	master := appkeytest.NewSession(testDID, "alice.test", appkeytest.MasterScope, time.Now().Add(time.Hour))
+	_, errs, _ = doConcurrently(&g, 10, func() (*atproto.ServerCreateSession_Output, error) {
+		return master, nil
+	})
+	for _, err := range errs {
+		if !errors.Is(err, ErrMasterCredentials) {
+			t.Errorf("Do = %v, want ErrMasterCredentials", err)
+		}
+	}
+}
+
+func TestRefreshGroupPanic(t *testing.T) {
+	var g RefreshGroup
+	sessions, errs, panics := doConcurrently(&g, 10, func() (*atproto.ServerCreateSession_Output, error) {
+		panic("boom")
+	})
+	if len(panics) != 1 || panics[0] != "boom" {
+		t.Errorf("panics = %v, want one panic in the refreshing goroutine", panics)
+	}
+	if len(errs) != 9 {
+		t.Fatalf("%d callers returned, want 9", len(errs))
+	}
+	for i, err := range errs {
+		if err == nil || sessions[i] != nil {
+			t.Errorf("Do = %v, %v; want nil and an error", sessions[i], err)
+		}
+	}
+
+	// The panic does not leave the refresh in flight.
+	fresh := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, time.Now().Add(time.Hour))
+	if _, err := g.Do(testDID, func() (*atproto.ServerCreateSession_Output, error) { return fresh, nil }); err != nil {
+		t.Errorf("Do after a panic: %v", err)
+	}
+}
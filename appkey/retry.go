@@ -0,0 +1,99 @@
+package appkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// IsRetryable reports whether err, as returned from Login or from a
+// caller's own createSession call, is a transient failure worth retrying,
+// such as a network timeout, a rate limit, or a 5xx response from the server.
+//
+// Authentication failures such as ErrLoginUnauthorized and ErrMasterCredentials
+// and other validation errors from this package are never retryable:
+// retrying them cannot succeed, and repeated failed logins can lock an account.
+func IsRetryable(err error) bool {
+	if err == nil || Classify(err) != CategoryUnknown {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if status, ok := xrpcStatus(err); ok {
+		return status == http.StatusTooManyRequests || status >= 500
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// xrpcStatus extracts the HTTP status code from an error returned by
+// indigo's xrpc client, which reports non-200 responses only as
+// text of the form "XRPC ERROR <code>: <status>".
+func xrpcStatus(err error) (int, bool) {
+	const prefix = "XRPC ERROR "
+	msg := err.Error()
+	i := strings.Index(msg, prefix)
+	if i < 0 {
+		return 0, false
+	}
+	var status int
+	if _, err := fmt.Sscanf(msg[i+len(prefix):], "%d", &status); err != nil {
+		return 0, false
+	}
+	return status, true
+}
+
+// FromXRPCError translates err, as returned by an xrpc call made with a
+// stored session, to the matching error of this package. The result wraps
+// both the sentinel and err:
+//   - an ExpiredToken error matches ErrSessionExpired, meaning the
+//     session should be refreshed
+//   - an AuthRequired or InvalidToken error, or any 401 response,
+//     matches ErrLoginUnauthorized
+//
+// Other errors, including nil, are returned unchanged.
+//
+// The version of indigo used by this package discards the error name from
+// the response body and reports only the HTTP status, so an ExpiredToken
+// error, which the PDS sends with a 400 status, is only recognized if its
+// name appears in the text of err.
+func FromXRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "ExpiredToken"):
+		return fmt.Errorf("%w: %w", ErrSessionExpired, err)
+	case strings.Contains(msg, "AuthRequired"), strings.Contains(msg, "InvalidToken"):
+		return fmt.Errorf("%w: %w", ErrLoginUnauthorized, err)
+	}
+	if status, ok := xrpcStatus(err); ok && status == http.StatusUnauthorized {
+		return fmt.Errorf("%w: %w", ErrLoginUnauthorized, err)
+	}
+	return err
+}
+
+// RateLimitRemaining returns the number of requests remaining in the
+// session's rate limit budget, reporting whether that information is
+// present. Neither ServerCreateSession_Output nor the tokens issued by
+// current PDS implementations carry rate limit metadata, which is instead
+// sent in the RateLimit-Remaining header of each response, so this
+// currently always returns 0 and false for a non-nil session. It exists
+// so that callers throttling their usage have a single accessor to
+// switch over to should sessions begin to include it.
+func RateLimitRemaining(sess *atproto.ServerCreateSession_Output) (int, bool, error) {
+	if sess == nil {
+		return 0, false, ErrNilSession
+	}
+	return 0, false, nil
+}
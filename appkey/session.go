@@ -0,0 +1,49 @@
+package appkey
+
+import (
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// Session wraps a createSession output with validated accessors.
+// It is an alternative to calling the equivalent package functions.
+type Session struct {
+	*atproto.ServerCreateSession_Output
+}
+
+// Valid validates the session with Check.
+func (s Session) Valid() error {
+	return Check(s.ServerCreateSession_Output)
+}
+
+// DID returns the DID of the account that owns the session. See DID.
+func (s Session) DID() (string, error) {
+	return DID(s.ServerCreateSession_Output)
+}
+
+// Expiry returns the expiration time of the session's access token.
+func (s Session) Expiry() (time.Time, error) {
+	return accessExpiry(s.ServerCreateSession_Output)
+}
+
+// ShouldRefresh reports whether the session's access token expires within d.
+// See ShouldRefresh.
+func (s Session) ShouldRefresh(d time.Duration) (bool, error) {
+	return ShouldRefresh(s.ServerCreateSession_Output, d)
+}
+
+// Clone returns a deep copy of sess, so that a session shared between
+// goroutines can be handed out without risk of one mutating another's copy.
+// Clone returns nil if sess is nil.
+func Clone(sess *atproto.ServerCreateSession_Output) *atproto.ServerCreateSession_Output {
+	if sess == nil {
+		return nil
+	}
+	c := *sess
+	if sess.Email != nil {
+		email := *sess.Email
+		c.Email = &email
+	}
+	return &c
+}
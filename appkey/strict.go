@@ -0,0 +1,133 @@
+package appkey
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// ErrMissingClaim is returned by strict validation if a recommended
+// claim is absent, and if a claim listed in CheckOptions.RequiredClaims
+// is absent. The error message names the missing claim.
+var ErrMissingClaim = errors.New("missing claim")
+
+// ErrUnexpectedTokenType is returned by strict validation if the typ header
+// of an access token is present but is not one of the accepted values.
+var ErrUnexpectedTokenType = errors.New("unexpected token type")
+
+// ErrTokenFromFuture is returned by strict validation if the iat claim of
+// an access token is later than the current time, allowing for
+// CheckOptions.Leeway. This indicates clock skew or a tampered token.
+var ErrTokenFromFuture = errors.New("token issued in the future")
+
+// ErrRefreshShorterThanAccess is returned by strict validation if the refresh
+// token of a session expires before its access token, which indicates a
+// malformed or swapped pair of tokens.
+var ErrRefreshShorterThanAccess = errors.New("refresh token expires before access token")
+
+// defaultTokenTypes are the typ header values accepted by strict validation
+// if CheckOptions.AcceptedTokenTypes is empty.
+var defaultTokenTypes = []string{"JWT", "at+jwt"}
+
+// StrictCheck performs the same validation as Check, and additionally:
+//   - requires the access token to have well-typed iat, exp, aud, sub,
+//     scope, and iss claims, returning ErrMissingClaim naming any that are absent
+//   - requires the iss claim to identify a service by a DID or https URL
+//   - requires each aud value to be a DID, returning ErrAudienceNotDID otherwise
+//   - rejects access tokens whose iat claim is in the future with ErrTokenFromFuture
+//   - rejects sessions whose refresh token expires before the access token
+//     with ErrRefreshShorterThanAccess
+//   - rejects unsigned tokens that use the "none" algorithm with ErrUnsafeAlgorithm
+//   - rejects access tokens whose typ header is present but is not "JWT"
+//     or "at+jwt" with ErrUnexpectedTokenType
+//
+// It is equivalent to CheckWith with CheckOptions.Strict set.
+func StrictCheck(sess *atproto.ServerCreateSession_Output, opts ...Option) error {
+	cfg := newConfig(opts)
+	cfg.Strict = true
+	_, err := inspect(sess, cfg)
+	return err
+}
+
+// checkStrictAccess applies the strict validation rules to the claims
+// and header of an access token. The type of each claim has already been
+// validated when parsing, so only presence is checked here. Each problem
+// is passed to cfg.report, so that CheckAllProblems reports all of them.
+func (cfg checkConfig) checkStrictAccess(c Claims, header map[string]interface{}) error {
+	var problems []error
+	add := func(err error) {
+		if err != nil {
+			problems = append(problems, err)
+		}
+	}
+	add(checkAlgorithm(header, cfg.AllowNoneAlgorithm))
+	add(cfg.checkTokenType(header))
+	if c.Scope == "" {
+		add(fmt.Errorf("%w: scope: %w", ErrMissingClaim, ErrMissingScope))
+	}
+	if c.Subject == "" {
+		add(fmt.Errorf("%w: sub", ErrMissingClaim))
+	}
+	if len(c.Audience) == 0 {
+		add(fmt.Errorf("%w: aud", ErrMissingClaim))
+	}
+	switch {
+	case c.IssuedAt.IsZero():
+		add(fmt.Errorf("%w: iat", ErrMissingClaim))
+	case c.IssuedAt.After(cfg.now.Add(cfg.Leeway)):
+		add(fmt.Errorf("%w: issued at %v", ErrTokenFromFuture, c.IssuedAt))
+	}
+	if c.ExpiresAt.IsZero() {
+		add(fmt.Errorf("%w: exp: %w", ErrMissingClaim, ErrMissingExpiration))
+	}
+	switch {
+	case c.Issuer == "":
+		add(fmt.Errorf("%w: iss", ErrMissingClaim))
+	case !wellFormedIssuer(c.Issuer):
+		add(fmt.Errorf("%w: malformed iss claim %q", ErrWrongIssuer, c.Issuer))
+	}
+	add(checkAudienceDIDs(c.Audience))
+	for _, err := range problems {
+		if err := cfg.report(err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTokenType checks that the typ header, if present, is one of the
+// accepted token types. Like the JWT specification, the comparison
+// ignores case.
+func (cfg checkConfig) checkTokenType(header map[string]interface{}) error {
+	raw, ok := header["typ"]
+	if !ok {
+		return nil
+	}
+	typ, ok := raw.(string)
+	if !ok {
+		return fmt.Errorf("%w: unexpected type for typ header: %T", ErrMalformedToken, raw)
+	}
+	accepted := cfg.AcceptedTokenTypes
+	if len(accepted) == 0 {
+		accepted = defaultTokenTypes
+	}
+	for _, t := range accepted {
+		if strings.EqualFold(typ, t) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrUnexpectedTokenType, typ)
+}
+
+// wellFormedIssuer reports whether iss identifies a service,
+// either by a DID or by an absolute https URL.
+func wellFormedIssuer(iss string) bool {
+	if strings.HasPrefix(iss, "did:") {
+		return len(iss) > len("did:")
+	}
+	u, err := url.Parse(iss)
+	return err == nil && u.Scheme == "https" && u.Host != ""
+}
@@ -0,0 +1,66 @@
+package appkey
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// SessionSummary is a representation of a session that is safe to log.
+// It never contains the raw access or refresh tokens.
+type SessionSummary struct {
+	DID           string
+	Handle        string
+	Scope         string
+	AccessExpiry  time.Time
+	RefreshExpiry time.Time
+}
+
+// Summarize returns a SessionSummary for sess.
+// No validation of the scopes or expiries is performed.
+func Summarize(sess *atproto.ServerCreateSession_Output) (SessionSummary, error) {
+	info, err := SessionInfo(sess)
+	if err != nil {
+		return SessionSummary{}, err
+	}
+	return SessionSummary{
+		DID:           sess.Did,
+		Handle:        sess.Handle,
+		Scope:         info.AccessScope,
+		AccessExpiry:  info.AccessExpiry,
+		RefreshExpiry: info.RefreshExpiry,
+	}, nil
+}
+
+// String returns a single line description of s.
+func (s SessionSummary) String() string {
+	return fmt.Sprintf("did=%s handle=%s scope=%s accessExpiry=%s refreshExpiry=%s",
+		s.DID, s.Handle, s.Scope, formatTime(s.AccessExpiry), formatTime(s.RefreshExpiry))
+}
+
+// MarshalJSON encodes s as a JSON object with RFC 3339 times.
+func (s SessionSummary) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		DID           string `json:"did"`
+		Handle        string `json:"handle"`
+		Scope         string `json:"scope"`
+		AccessExpiry  string `json:"accessExpiry,omitempty"`
+		RefreshExpiry string `json:"refreshExpiry,omitempty"`
+	}{
+		DID:           s.DID,
+		Handle:        s.Handle,
+		Scope:         s.Scope,
+		AccessExpiry:  formatTime(s.AccessExpiry),
+		RefreshExpiry: formatTime(s.RefreshExpiry),
+	})
+}
+
+// formatTime formats t as RFC 3339, or as an empty string if t is zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
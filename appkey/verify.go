@@ -0,0 +1,188 @@
+package appkey
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrInvalidSignature is returned if a token's signature cannot be
+	// verified with the provided key.
+	ErrInvalidSignature = errors.New("invalid token signature")
+
+	// ErrUnsafeAlgorithm is returned if a token uses the "none" algorithm,
+	// which means it is unsigned, where a signature is required.
+	ErrUnsafeAlgorithm = errors.New("unsafe token algorithm")
+
+	// ErrUnexpectedAlgorithm is returned if a token's alg header names an
+	// algorithm family that does not match the verification key, such as
+	// HS256 with an ECDSA public key, which guards against algorithm
+	// confusion attacks.
+	ErrUnexpectedAlgorithm = errors.New("unexpected token algorithm")
+)
+
+// VerifySignature parses accessJwt with full signature verification
+// against key, which is typically the signing key of the PDS that issued the token.
+// The type of key must match the token's signing method, such as
+// *ecdsa.PublicKey for ES256 or []byte for HS256.
+//
+// Tokens signed with ES256K, the secp256k1 algorithm used by many atproto
+// signing keys, cannot be verified at all, because the jwt package used by
+// this package does not implement ES256K. Verifying such a token returns an
+// error whatever the key; use AlgorithmOf to detect them beforehand.
+//
+// Only the signature is verified. Time based checks are left to Check,
+// which does not itself verify signatures unless CheckOptions.VerifyWith is set.
+// Unsigned tokens using the "none" algorithm are rejected with ErrUnsafeAlgorithm,
+// and tokens whose algorithm family does not match the type of key,
+// as reported by AlgorithmOf, are rejected with ErrUnexpectedAlgorithm.
+func VerifySignature(accessJwt string, key crypto.PublicKey) error {
+	token, _, err := parseTokenWith(newParser(), accessJwt)
+	if err != nil {
+		return err
+	}
+	return verifyToken(accessJwt, token.Header, StaticKey(key))
+}
+
+// StaticKey returns a jwt.Keyfunc that always returns key, for use as
+// CheckOptions.VerifyWith when tokens are signed with a single known key.
+func StaticKey(key crypto.PublicKey) jwt.Keyfunc {
+	return func(*jwt.Token) (interface{}, error) { return key, nil }
+}
+
+// verifyToken verifies the signature of tokenString, whose header has
+// already been parsed, with the key returned by keyFunc. The checks of
+// VerifySignature on the algorithm apply, as do any of opts that restrict
+// the signing method. The claims are not validated.
+func verifyToken(tokenString string, header map[string]interface{}, keyFunc jwt.Keyfunc, opts ...jwt.ParserOption) error {
+	if err := checkAlgorithm(header, false); err != nil {
+		return err
+	}
+	checkedKeyFunc := func(token *jwt.Token) (interface{}, error) {
+		key, err := keyFunc(token)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkAlgorithmFamily(token.Header, key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	opts = append(opts[:len(opts):len(opts)], jwt.WithoutClaimsValidation())
+	if _, err := newParser(opts...).Parse(tokenString, checkedKeyFunc); err != nil {
+		if errors.Is(err, ErrUnexpectedAlgorithm) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+	return nil
+}
+
+// KeyID returns the kid header of tokenString, which identifies the key
+// that signed it, for example to select a key from a JWKS before calling
+// VerifySignature. An empty string is returned if the token has no kid header.
+// Only the header is decoded, so KeyID works for any signing algorithm.
+func KeyID(tokenString string) (string, error) {
+	header, err := decodeHeader(tokenString)
+	if err != nil {
+		return "", err
+	}
+	raw, ok := header["kid"]
+	if !ok {
+		return "", nil
+	}
+	kid, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: unexpected type for kid header: %T", ErrMalformedToken, raw)
+	}
+	return kid, nil
+}
+
+// AlgorithmOf returns the alg header of tokenString, which names the
+// algorithm used to sign it, such as "ES256K" or "ES256" for atproto
+// signing keys, or "HS256" for tokens signed with a PDS secret.
+// Only the header is decoded, so AlgorithmOf works for any signing
+// algorithm, including ES256K, which the jwt package does not implement.
+func AlgorithmOf(tokenString string) (string, error) {
+	header, err := decodeHeader(tokenString)
+	if err != nil {
+		return "", err
+	}
+	alg, ok := header["alg"].(string)
+	if !ok || alg == "" {
+		return "", fmt.Errorf("%w: missing or invalid alg header", ErrMalformedToken)
+	}
+	return alg, nil
+}
+
+// decodeHeader decodes the header segment of tokenString without
+// interpreting the rest of the token. Unlike the jwt package, it does not
+// require the signing algorithm to be one that the jwt package implements.
+// As with parseClaims, a padded segment is tolerated.
+func decodeHeader(tokenString string) (map[string]interface{}, error) {
+	parts := strings.Split(tokenString, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: token contains an invalid number of segments", ErrMalformedToken)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[0], "="))
+	if err != nil {
+		return nil, fmt.Errorf("%w: decoding header: %w", ErrMalformedToken, err)
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("%w: decoding header: %w", ErrMalformedToken, err)
+	}
+	if header == nil {
+		return nil, fmt.Errorf("%w: header is not a JSON object", ErrMalformedToken)
+	}
+	return header, nil
+}
+
+// checkAlgorithmFamily rejects a token header whose alg does not belong to
+// the algorithm family implied by the type of key.
+func checkAlgorithmFamily(header map[string]interface{}, key crypto.PublicKey) error {
+	var want string
+	switch key.(type) {
+	case *ecdsa.PublicKey:
+		want = "ES"
+	case *rsa.PublicKey:
+		want = "RS"
+	case ed25519.PublicKey:
+		want = "EdDSA"
+	case []byte:
+		want = "HS"
+	default:
+		return fmt.Errorf("%w: unsupported key type %T", ErrUnexpectedAlgorithm, key)
+	}
+	alg, _ := header["alg"].(string)
+	family := alg
+	if alg != "EdDSA" && len(alg) > 2 {
+		family = alg[:2]
+	}
+	if family == "PS" {
+		family = "RS"
+	}
+	if family != want {
+		return fmt.Errorf("%w: %q cannot be verified with a key of type %T", ErrUnexpectedAlgorithm, alg, key)
+	}
+	return nil
+}
+
+// checkAlgorithm rejects a token header whose alg is "none",
+// unless allowNone is set.
+func checkAlgorithm(header map[string]interface{}, allowNone bool) error {
+	alg, _ := header["alg"].(string)
+	if strings.EqualFold(alg, "none") && !allowNone {
+		return fmt.Errorf("%w: %q", ErrUnsafeAlgorithm, alg)
+	}
+	return nil
+}
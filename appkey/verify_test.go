@@ -0,0 +1,93 @@
+package appkey
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/thepudds/bluesky-aux/appkey/appkeytest"
+)
+
+func TestAlgorithmOfUnregisteredAlgorithm(t *testing.T) {
+	// The jwt package does not implement ES256K, so parsing a token signed
+	// with it fails, but its header can still be read.
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256K","typ":"JWT","kid":"atproto"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"scope":"com.atproto.appPass"}`))
+	tok := header + "." + payload + ".c2ln"
+
+	alg, err := AlgorithmOf(tok)
+	if err != nil || alg != "ES256K" {
+		t.Errorf("AlgorithmOf = %q, %v; want ES256K", alg, err)
+	}
+	kid, err := KeyID(tok)
+	if err != nil || kid != "atproto" {
+		t.Errorf("KeyID = %q, %v; want atproto", kid, err)
+	}
+	if err := VerifySignature(tok, []byte("secret")); err == nil {
+		t.Error("VerifySignature succeeded for an ES256K token")
+	}
+}
+
+func TestAlgorithmOfMalformed(t *testing.T) {
+	for _, tok := range []string{
+		"",
+		"a.b",
+		"!!!.e30.c2ln",
+		base64.RawURLEncoding.EncodeToString([]byte(`[]`)) + ".e30.c2ln",
+		base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT"}`)) + ".e30.c2ln",
+	} {
+		if _, err := AlgorithmOf(tok); !errors.Is(err, ErrMalformedToken) {
+			t.Errorf("AlgorithmOf(%q) = %v; want ErrMalformedToken", tok, err)
+		}
+	}
+}
+
+// noneToken returns tok re-encoded as an unsigned token using the "none" algorithm.
+func noneToken(tok string) string {
+	_, rest, _ := strings.Cut(tok, ".")
+	payload, _, _ := strings.Cut(rest, ".")
+	return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + payload + "."
+}
+
+func TestVerifyWith(t *testing.T) {
+	sess := appkeytest.NewSession(testDID, "alice.test", appkeytest.AppPassScope, time.Now().Add(time.Hour))
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := *sess
+	unsigned.AccessJwt = noneToken(sess.AccessJwt)
+	unsigned.RefreshJwt = noneToken(sess.RefreshJwt)
+
+	tests := []struct {
+		name string
+		sess *atproto.ServerCreateSession_Output
+		opts CheckOptions
+		want error
+	}{
+		{"nil VerifyWith", sess, CheckOptions{}, nil},
+		{"good key", sess, CheckOptions{VerifyWith: StaticKey(appkeytest.Key)}, nil},
+		{"wrong key", sess, CheckOptions{VerifyWith: StaticKey([]byte("wrong key"))}, ErrInvalidSignature},
+		{"key func error", sess, CheckOptions{VerifyWith: func(*jwt.Token) (interface{}, error) { return nil, errors.New("no such key") }}, ErrInvalidSignature},
+		{"algorithm family mismatch", sess, CheckOptions{VerifyWith: StaticKey(&ecKey.PublicKey)}, ErrUnexpectedAlgorithm},
+		{"none without VerifyWith", &unsigned, CheckOptions{}, nil},
+		{"none with AllowNoneAlgorithm", &unsigned, CheckOptions{Strict: true, AllowNoneAlgorithm: true}, nil},
+		{"none when strict", &unsigned, CheckOptions{Strict: true}, ErrUnsafeAlgorithm},
+		{"none when verifying", &unsigned, CheckOptions{VerifyWith: StaticKey(appkeytest.Key)}, ErrUnsafeAlgorithm},
+		{"none when verifying with AllowNoneAlgorithm", &unsigned, CheckOptions{VerifyWith: StaticKey(appkeytest.Key), AllowNoneAlgorithm: true}, ErrUnsafeAlgorithm},
+		{"none when verifying strictly with AllowNoneAlgorithm", &unsigned, CheckOptions{Strict: true, VerifyWith: StaticKey(appkeytest.Key), AllowNoneAlgorithm: true}, ErrUnsafeAlgorithm},
+	}
+	for _, tt := range tests {
+		err := CheckWith(tt.sess, tt.opts)
+		if !errors.Is(err, tt.want) || (tt.want == nil) != (err == nil) {
+			t.Errorf("%s: CheckWith = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/thepudds/bluesky-aux
 
-go 1.20
+go 1.21
 
 require (
 	github.com/bluesky-social/indigo v0.0.0-20230501012521-d8d3324b7afa